@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// First file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// Number of sockets passed by systemd socket activation, 0 if proxy wasn't socket-activated
+func listenFDs() (int, error) {
+	fds := os.Getenv("LISTEN_FDS")
+	if fds == "" {
+		return 0, nil
+	}
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// sockets were passed to another process
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Wrong LISTEN_FDS value %q", fds)
+	}
+
+	return n, nil
+}
+
+// Listener for slave connections, either passed by systemd or bound by proxy itself
+func proxyListener(network, address string) (net.Listener, bool, error) {
+	n, err := listenFDs()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if n == 0 {
+		ln, err := net.Listen(network, address)
+		return ln, false, err
+	}
+
+	// don't pass sockets to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer file.Close()
+
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, true, fmt.Errorf("Failed to use socket passed by systemd: %v", err)
+	}
+
+	return ln, true, nil
+}
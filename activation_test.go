@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestListenFDs(t *testing.T) {
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_PID")
+
+	tests := []struct {
+		description string
+		fds         string
+		pid         string
+		expected    int
+		err         bool
+	}{
+		{"1: not activated", "", "", 0, false},
+		{"2: one socket", "1", strconv.Itoa(os.Getpid()), 1, false},
+		{"3: no pid", "2", "", 2, false},
+		{"4: other process", "1", "1", 0, false},
+		{"5: garbage", "x", "", 0, true},
+	}
+
+	for _, test := range tests {
+		os.Setenv("LISTEN_FDS", test.fds)
+		os.Setenv("LISTEN_PID", test.pid)
+
+		n, err := listenFDs()
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error %v (test %s)", err, test.description)
+		}
+		if n != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", n, test.expected, test.description)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config key holding regular expressions which are otherwise passed as arguments
+const configRegexpKey = "regexp"
+
+// configEntry is single value from config file, line is kept for error messages
+type configEntry struct {
+	key, value string
+	line       int
+}
+
+// Parse config file, simple subset of YAML & TOML is supported:
+//
+//	# comment
+//	master-host: redis1       (or master-host = "redis1")
+//	prefix: [user:, session:]
+//	db:
+//	  - 0
+//	  - 2
+//
+// Keys are flag names, list values are applied one by one, just like repeated flags
+func parseConfig(reader io.Reader) ([]configEntry, error) {
+	var (
+		result  []configEntry
+		listKey string
+	)
+
+	scanner := bufio.NewScanner(reader)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			// YAML block list item
+			if listKey == "" {
+				return nil, fmt.Errorf("Line %d: list item without key", lineNo)
+			}
+			value, err := parseConfigValue(strings.TrimPrefix(line, "-"))
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %v", lineNo, err)
+			}
+			result = append(result, configEntry{listKey, value, lineNo})
+			continue
+		}
+
+		separator := strings.IndexAny(line, ":=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("Line %d: expected key: value or key = value", lineNo)
+		}
+
+		key := strings.TrimSpace(line[:separator])
+		raw := strings.TrimSpace(line[separator+1:])
+		listKey = ""
+
+		if raw == "" || raw[0] == '#' {
+			// values follow as block list
+			listKey = key
+			continue
+		}
+
+		if raw[0] == '[' {
+			if raw[len(raw)-1] != ']' {
+				return nil, fmt.Errorf("Line %d: unterminated list", lineNo)
+			}
+			for _, item := range splitConfigList(raw[1 : len(raw)-1]) {
+				if strings.TrimSpace(item) == "" {
+					continue
+				}
+				value, err := parseConfigValue(item)
+				if err != nil {
+					return nil, fmt.Errorf("Line %d: %v", lineNo, err)
+				}
+				result = append(result, configEntry{key, value, lineNo})
+			}
+			continue
+		}
+
+		value, err := parseConfigValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", lineNo, err)
+		}
+		result = append(result, configEntry{key, value, lineNo})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read config: %v", err)
+	}
+
+	return result, nil
+}
+
+// Split inline list on commas which are outside of quotes and brackets, so that
+// regular expressions like ^a{1,3} or [a,b] are kept whole
+func splitConfigList(raw string) []string {
+	var (
+		items []string
+		quote byte
+		depth int
+		start int
+	)
+
+	for i := 0; i < len(raw); i++ {
+		switch c := raw[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && strings.TrimSpace(raw[start:i]) == "":
+			// quotes are recognized at the start of item only, like in YAML
+			quote = c
+		case c == '\\':
+			// escaped bracket in regular expression
+			i++
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case (c == ']' || c == '}' || c == ')') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, raw[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(items, raw[start:])
+}
+
+// Parse scalar value, strip quotes & trailing comment
+func parseConfigValue(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+
+	if strings.HasPrefix(raw, "\"") {
+		// find closing quote, skipping escaped characters
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '\\' {
+				i++
+			} else if raw[i] == '"' {
+				value, err := strconv.Unquote(raw[:i+1])
+				if err != nil {
+					return "", fmt.Errorf("Wrong quoted value %s", raw)
+				}
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("Wrong quoted value %s", raw)
+	}
+
+	if strings.HasPrefix(raw, "'") {
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("Wrong quoted value %s", raw)
+		}
+		return raw[1 : end+1], nil
+	}
+
+	if comment := strings.Index(raw, " #"); comment >= 0 {
+		raw = strings.TrimSpace(raw[:comment])
+	}
+
+	return raw, nil
+}
+
+// Apply config entries to flags which weren't set on command line, regular expressions
+// from config are returned separately
+func applyConfig(flags *flag.FlagSet, entries []configEntry) ([]string, error) {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var regexps []string
+	seen := make(map[string]bool)
+
+	for _, entry := range entries {
+		if entry.key == configRegexpKey {
+			regexps = append(regexps, entry.value)
+			continue
+		}
+
+		f := flags.Lookup(entry.key)
+		if entry.key == "config" || f == nil {
+			return nil, fmt.Errorf("Line %d: unknown config key %q", entry.line, entry.key)
+		}
+
+		// only flags which could be repeated accept lists, otherwise the last value would silently win
+		if _, repeatable := f.Value.(*stringList); seen[entry.key] && !repeatable {
+			return nil, fmt.Errorf("Line %d: %s doesn't accept several values", entry.line, entry.key)
+		}
+		seen[entry.key] = true
+
+		if explicit[entry.key] {
+			continue
+		}
+
+		err := flags.Set(entry.key, entry.value)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: wrong value for %s: %v", entry.line, entry.key, err)
+		}
+	}
+
+	return regexps, nil
+}
+
+// Load config file and apply it to flags
+func loadConfig(flags *flag.FlagSet, path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open config: %v", err)
+	}
+	defer file.Close()
+
+	entries, err := parseConfig(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyConfig(flags, entries)
+}
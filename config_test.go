@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		expected    []configEntry
+		expectedErr string
+	}{
+		{
+			description: "1: YAML",
+			input:       "# proxy\nmaster-host: redis1 # comment\nmaster-port: 6379\n",
+			expected:    []configEntry{{"master-host", "redis1", 2}, {"master-port", "6379", 3}},
+		},
+		{
+			description: "2: TOML",
+			input:       "master-host = \"redis1\"\nrewrite = '/^a:/b:/'\n",
+			expected:    []configEntry{{"master-host", "redis1", 1}, {"rewrite", "/^a:/b:/", 2}},
+		},
+		{
+			description: "3: inline list",
+			input:       "db: [0, \"2-3\"]\n",
+			expected:    []configEntry{{"db", "0", 1}, {"db", "2-3", 1}},
+		},
+		{
+			description: "4: block list",
+			input:       "regexp:\n  - ^user:\n  - \"^a b\"\nproxy-port: 6380\n",
+			expected:    []configEntry{{"regexp", "^user:", 2}, {"regexp", "^a b", 3}, {"proxy-port", "6380", 4}},
+		},
+		{
+			description: "5: inline list with commas inside quotes and brackets",
+			input:       "regexp: [^a{1,3}, \"b,c\", '[d,e]', ^(f|g,h)$, it's, \\[i, j]\n",
+			expected:    []configEntry{{"regexp", "^a{1,3}", 1}, {"regexp", "b,c", 1}, {"regexp", "[d,e]", 1}, {"regexp", "^(f|g,h)$", 1}, {"regexp", "it's", 1}, {"regexp", "\\[i", 1}, {"regexp", "j", 1}},
+		},
+		{
+			description: "6: list item without key",
+			input:       "master-port: 1\n- 2\n",
+			expectedErr: "Line 2: list item without key",
+		},
+		{
+			description: "7: no separator",
+			input:       "master-host\n",
+			expectedErr: "Line 1: expected key: value or key = value",
+		},
+		{
+			description: "8: unterminated quote",
+			input:       "master-host: \"redis1\n",
+			expectedErr: "Line 1: Wrong quoted value \"redis1",
+		},
+	}
+
+	for _, test := range tests {
+		entries, err := parseConfig(bytes.NewBufferString(test.input))
+		if test.expectedErr != "" {
+			if err == nil || err.Error() != test.expectedErr {
+				t.Errorf("Expected error %q, got %v (test %s)", test.expectedErr, err, test.description)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+			continue
+		}
+		if !reflect.DeepEqual(entries, test.expected) {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", entries, test.expected, test.description)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	host := flags.String("master-host", "localhost", "")
+	port := flags.Int("master-port", 6379, "")
+	flags.Int("proxy-port", 6380, "")
+	var dbs stringList
+	flags.Var(&dbs, "db", "")
+
+	err := flags.Parse([]string{"-master-port=7000"})
+	if err != nil {
+		t.Fatalf("Unable to parse flags: %v", err)
+	}
+
+	regexps, err := applyConfig(flags, []configEntry{
+		{"master-host", "redis1", 1},
+		{"master-port", "6379", 2},
+		{"db", "0", 3},
+		{"db", "2", 3},
+		{"regexp", "^user:", 4},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if *host != "redis1" {
+		t.Errorf("Config value should be applied: %s", *host)
+	}
+	if *port != 7000 {
+		t.Errorf("Command line flag should override config: %d", *port)
+	}
+	if !reflect.DeepEqual([]string(dbs), []string{"0", "2"}) {
+		t.Errorf("List values should be applied one by one: %#v", dbs)
+	}
+	if !reflect.DeepEqual(regexps, []string{"^user:"}) {
+		t.Errorf("Regular expressions don't match: %#v", regexps)
+	}
+
+	_, err = applyConfig(flags, []configEntry{{"master-hots", "redis1", 5}})
+	if err == nil || err.Error() != "Line 5: unknown config key \"master-hots\"" {
+		t.Errorf("Unknown key should be reported: %v", err)
+	}
+
+	_, err = applyConfig(flags, []configEntry{{"proxy-port", "x", 6}})
+	if err == nil {
+		t.Errorf("Wrong value should be reported")
+	}
+
+	_, err = applyConfig(flags, []configEntry{{"proxy-port", "6381", 7}, {"proxy-port", "6382", 7}})
+	if err == nil || err.Error() != "Line 7: proxy-port doesn't accept several values" {
+		t.Errorf("List for non-repeatable flag should be reported: %v", err)
+	}
+}
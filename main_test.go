@@ -1,87 +1,120 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"fmt"
-	"io"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
 )
 
-func TestReadRedisCommand(t *testing.T) {
+func TestMasterAddress(t *testing.T) {
+	masterHost, masterPort = "redis1.srv", 6400
+	defer func() { masterSocket = "" }()
+
+	network, address := masterAddress()
+	if network != "tcp" || address != "redis1.srv:6400" {
+		t.Errorf("Master address doesn't match: %s %s", network, address)
+	}
+
+	masterHost = "::1"
+
+	network, address = masterAddress()
+	if network != "tcp" || address != "[::1]:6400" {
+		t.Errorf("Master address doesn't match: %s %s", network, address)
+	}
+
+	masterSocket = "/var/run/redis/redis.sock"
+
+	network, address = masterAddress()
+	if network != "unix" || address != "/var/run/redis/redis.sock" {
+		t.Errorf("Master address doesn't match: %s %s", network, address)
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	tests := []struct {
+		host     string
+		port     int
+		expected string
+	}{
+		{"localhost", 6379, "localhost:6379"},
+		{"", 6380, ":6380"},
+		{"::1", 6379, "[::1]:6379"},
+		{"[fe80::1]", 6379, "[fe80::1]:6379"},
+		{"10.0.0.1", 6379, "10.0.0.1:6379"},
+	}
+
+	for _, test := range tests {
+		address := hostPort(test.host, test.port)
+		if address != test.expected {
+			t.Errorf("Address doesn't match: %q != %q", address, test.expected)
+		}
+	}
+}
+
+func TestProxyAddresses(t *testing.T) {
+	proxyHost, proxyPort = "10.0.0.1, ::1", 6380
+	defer func() { proxyHost, proxySocket = "", "" }()
+
+	network, addresses := proxyAddresses()
+	if network != "tcp" || !reflect.DeepEqual(addresses, []string{"10.0.0.1:6380", "[::1]:6380"}) {
+		t.Errorf("Proxy addresses don't match: %s %#v", network, addresses)
+	}
+
+	proxyHost = ""
+
+	network, addresses = proxyAddresses()
+	if network != "tcp" || !reflect.DeepEqual(addresses, []string{":6380"}) {
+		t.Errorf("Proxy addresses don't match: %s %#v", network, addresses)
+	}
+
+	proxySocket = "/var/run/proxy.sock"
+
+	network, addresses = proxyAddresses()
+	if network != "unix" || !reflect.DeepEqual(addresses, []string{"/var/run/proxy.sock"}) {
+		t.Errorf("Proxy addresses don't match: %s %#v", network, addresses)
+	}
+}
+
+func TestMasterPassword(t *testing.T) {
+	file, err := ioutil.TempFile("", "password")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("from-file\n")
+	file.Close()
+
+	defer os.Unsetenv(masterPasswordEnv)
+
 	tests := []struct {
-		description   string
-		input         string
-		expected      redisCommand
-		expectedError error
+		description string
+		auth        string
+		env         string
+		path        string
+		expected    string
 	}{
-		{
-			description:   "1: Reply",
-			input:         "+PONG\r\n",
-			expected:      redisCommand{reply: "PONG"},
-			expectedError: nil,
-		},
-		{
-			description:   "2: Empty command",
-			input:         "\n",
-			expected:      redisCommand{},
-			expectedError: nil,
-		},
-		{
-			description:   "3: Simple command",
-			input:         "SYNC\r\n",
-			expected:      redisCommand{command: []string{"SYNC"}},
-			expectedError: nil,
-		},
-		{
-			description:   "4: Bulk reply",
-			input:         "$4568\r\n",
-			expected:      redisCommand{bulkSize: 4568},
-			expectedError: nil,
-		},
-		{
-			description:   "5: Complex command",
-			input:         "*3\r\n$3\r\nSET\r\n$5\r\nmykey\r\n$7\r\nmyvalue\r\n",
-			expected:      redisCommand{command: []string{"SET", "mykey", "myvalue"}},
-			expectedError: nil,
-		},
-		{
-			description:   "6: Immediate EOF",
-			input:         "+PONG",
-			expected:      redisCommand{},
-			expectedError: fmt.Errorf("Failed to read command: %v", io.EOF),
-		},
-		{
-			description:   "7: EOF in length",
-			input:         "*3\r\n$3",
-			expected:      redisCommand{},
-			expectedError: fmt.Errorf("Failed to read command: %v", io.EOF),
-		},
-		{
-			description:   "8: EOF in data",
-			input:         "*3\r\n$3\r\nSE",
-			expected:      redisCommand{},
-			expectedError: fmt.Errorf("Failed to read argument: %v", io.ErrUnexpectedEOF),
-		},
-		{
-			description:   "9: Unparsable length",
-			input:         "*x\r\n",
-			expected:      redisCommand{},
-			expectedError: fmt.Errorf("Unable to parse command length: strconv.ParseInt: parsing \"x\": invalid syntax"),
-		},
+		{"1: Flag wins", "from-flag", "from-env", file.Name(), "from-flag"},
+		{"2: Environment wins over file", "", "from-env", file.Name(), "from-env"},
+		{"3: File, newline trimmed", "", "", file.Name(), "from-file"},
+		{"4: Nothing", "", "", "", ""},
 	}
 
 	for _, test := range tests {
-		test.expected.raw = []byte(test.input)
+		os.Setenv(masterPasswordEnv, test.env)
 
-		command, err := readRedisCommand(bufio.NewReader(bytes.NewBufferString(test.input)))
+		password, err := masterPassword(test.auth, test.path)
 		if err != nil {
-			if test.expectedError == nil || test.expectedError.Error() != err.Error() {
-				t.Errorf("Unexpected error: %v (test %s)", err, test.description)
-			}
-		} else if !reflect.DeepEqual(*command, test.expected) {
-			t.Errorf("Output not equal to expected %#v != %#v (test %s)", *command, test.expected, test.description)
+			t.Errorf("Unable to get password: %v (test %s)", err, test.description)
+		}
+		if password != test.expected {
+			t.Errorf("Password doesn't match: %q != %q (test %s)", password, test.expected, test.description)
 		}
 	}
+
+	_, err = masterPassword("", file.Name()+".missing")
+	if err == nil {
+		t.Errorf("Missing password file should fail")
+	}
 }
@@ -0,0 +1,204 @@
+package resharding
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec describes positions of keys in command arguments
+type keySpec struct {
+	// position of first key, 0 for commands without keys
+	first int
+	// position of last key, negative is counted from the end
+	last int
+	// step between keys, e.g. 2 for MSET key value [key value ...]
+	step int
+	// command could be rewritten to keep only matching keys (with their values)
+	split bool
+	// position of argument with number of keys which follow it, e.g. 2 for ZUNIONSTORE destination numkeys key [key ...]
+	numkeys int
+	// option followed by key, e.g. STORE for SORT key [...] STORE destination
+	keyOption string
+}
+
+var noKeys = keySpec{}
+
+// Key positions for common write commands, commands which are not listed here
+// are assumed to have single key as the first argument
+var commandTable = map[string]keySpec{
+	"DEL":    {first: 1, last: -1, step: 1, split: true},
+	"UNLINK": {first: 1, last: -1, step: 1, split: true},
+	"MSET":   {first: 1, last: -1, step: 2, split: true},
+	"MSETNX": {first: 1, last: -1, step: 2, split: true},
+
+	"RENAME":      {first: 1, last: 2, step: 1},
+	"RENAMENX":    {first: 1, last: 2, step: 1},
+	"RPOPLPUSH":   {first: 1, last: 2, step: 1},
+	"LMOVE":       {first: 1, last: 2, step: 1},
+	"SMOVE":       {first: 1, last: 2, step: 1},
+	"SDIFFSTORE":  {first: 1, last: -1, step: 1},
+	"SINTERSTORE": {first: 1, last: -1, step: 1},
+	"SUNIONSTORE": {first: 1, last: -1, step: 1},
+	"BITOP":       {first: 2, last: -1, step: 1},
+	"PFMERGE":     {first: 1, last: -1, step: 1},
+	"COPY":        {first: 1, last: 2, step: 1},
+	"ZRANGESTORE": {first: 1, last: 2, step: 1},
+	"ZUNIONSTORE": {first: 1, last: 1, step: 1, numkeys: 2},
+	"ZINTERSTORE": {first: 1, last: 1, step: 1, numkeys: 2},
+	"ZDIFFSTORE":  {first: 1, last: 1, step: 1, numkeys: 2},
+	// GEOSEARCHSTORE destination source ...
+	"GEOSEARCHSTORE": {first: 1, last: 2, step: 1},
+	"SORT":           {first: 1, last: 1, step: 1, keyOption: "STORE"},
+	// XGROUP CREATE key group id, master propagates group changes made by XREADGROUP this way
+	"XGROUP": {first: 2, last: 2, step: 1},
+
+	"SELECT":   noKeys,
+	"SWAPDB":   noKeys,
+	"FLUSHDB":  noKeys,
+	"FLUSHALL": noKeys,
+	"MULTI":    noKeys,
+	"EXEC":     noKeys,
+	"PING":     noKeys,
+	// REPLCONF GETACK * is sent by master to request replication offset from slave
+	"REPLCONF": noKeys,
+}
+
+// Look up key positions of the command
+func commandKeySpec(name string) keySpec {
+	spec, ok := commandTable[strings.ToUpper(name)]
+	if !ok {
+		return keySpec{first: 1, last: 1, step: 1}
+	}
+	return spec
+}
+
+// Positions of keys in command arguments, none for commands without keys
+func commandKeyPositions(command *redisCommand) []int {
+	if len(command.command) == 0 {
+		return nil
+	}
+
+	spec := commandKeySpec(command.command[0])
+	if spec.first == 0 {
+		return nil
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(command.command)
+	}
+
+	var positions []int
+	for i := spec.first; i <= last && i < len(command.command); i += spec.step {
+		positions = append(positions, i)
+	}
+
+	if spec.numkeys > 0 && spec.numkeys < len(command.command) {
+		numkeys, err := strconv.Atoi(command.command[spec.numkeys])
+		if err == nil {
+			for i := spec.numkeys + 1; i <= spec.numkeys+numkeys && i < len(command.command); i++ {
+				positions = append(positions, i)
+			}
+		}
+	}
+
+	if spec.keyOption != "" {
+		for i := spec.first + 1; i+1 < len(command.command); i++ {
+			if strings.EqualFold(command.command[i], spec.keyOption) {
+				positions = append(positions, i+1)
+				i++
+			}
+		}
+	}
+
+	return positions
+}
+
+// Check whether command operates on keys
+func commandHasKeys(command *redisCommand) bool {
+	return len(commandKeyPositions(command)) > 0
+}
+
+// Describe command for debug log: name and keys, other arguments are redacted unless logValues is set
+func commandLogString(command *redisCommand, logValues bool) string {
+	if logValues || len(command.command) == 0 {
+		return strings.Join(command.command, " ")
+	}
+
+	spec := commandKeySpec(command.command[0])
+	if spec.first == 0 {
+		// arguments of commands without keys (SELECT, FLUSHALL, ...) aren't data
+		return strings.Join(command.command, " ")
+	}
+
+	args := make([]string, len(command.command))
+	args[0] = command.command[0]
+	for i := 1; i < len(args); i++ {
+		args[i] = "<redacted>"
+	}
+	for _, i := range commandKeyPositions(command) {
+		args[i] = command.command[i]
+	}
+
+	return strings.Join(args, " ")
+}
+
+// Database number from SELECT command
+func selectedDB(command *redisCommand) (db int, ok bool) {
+	if len(command.command) != 2 || strings.ToUpper(command.command[0]) != "SELECT" {
+		return 0, false
+	}
+
+	db, err := strconv.Atoi(command.command[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return db, true
+}
+
+// Filter command from master by its keys, returns whether command should be forwarded
+//
+// Commands which could be split (DEL, MSET, ...) are rewritten to include only matching keys
+// (with their values), other multi-key commands are kept or dropped according to the first key.
+func filterCommand(command *redisCommand, match func(string) bool) bool {
+	if len(command.command) == 0 {
+		return true
+	}
+
+	spec := commandKeySpec(command.command[0])
+	if spec.first == 0 || spec.first >= len(command.command) {
+		return true
+	}
+
+	if !spec.split {
+		return match(command.command[spec.first])
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(command.command)
+	}
+
+	args := append([]string{}, command.command[:spec.first]...)
+	dropped := false
+
+	for i := spec.first; i <= last && i+spec.step <= len(command.command); i += spec.step {
+		if match(command.command[i]) {
+			args = append(args, command.command[i:i+spec.step]...)
+		} else {
+			dropped = true
+		}
+	}
+
+	if len(args) == spec.first {
+		return false
+	}
+
+	if dropped {
+		command.command = args
+		command.raw = encodeRedisCommand(args...)
+	}
+
+	return true
+}
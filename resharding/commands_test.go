@@ -0,0 +1,98 @@
+package resharding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterCommand(t *testing.T) {
+	match := func(key string) bool { return strings.HasPrefix(key, "a") }
+
+	tests := []struct {
+		description string
+		command     []string
+		keep        bool
+		expected    []string
+	}{
+		{"1: Single key, match", []string{"SET", "a1", "v"}, true, []string{"SET", "a1", "v"}},
+		{"2: Single key, no match", []string{"SET", "b1", "v"}, false, nil},
+		{"3: DEL, some match", []string{"DEL", "a1", "b1", "a2"}, true, []string{"DEL", "a1", "a2"}},
+		{"4: DEL, none match", []string{"DEL", "b1", "b2"}, false, nil},
+		{"5: UNLINK, all match", []string{"unlink", "a1", "a2"}, true, []string{"unlink", "a1", "a2"}},
+		{"6: MSET, some match", []string{"MSET", "b1", "1", "a1", "2", "b2", "3"}, true, []string{"MSET", "a1", "2"}},
+		{"7: MSET, none match", []string{"MSET", "b1", "1"}, false, nil},
+		{"8: No keys", []string{"SELECT", "1"}, true, []string{"SELECT", "1"}},
+		{"9: No arguments", []string{"MULTI"}, true, []string{"MULTI"}},
+		{"10: Multi-key, first matches", []string{"RENAME", "a1", "b1"}, true, []string{"RENAME", "a1", "b1"}},
+		{"11: Multi-key, first doesn't match", []string{"RENAME", "b1", "a1"}, false, nil},
+		{"12: BITOP", []string{"BITOP", "AND", "a1", "b1"}, true, []string{"BITOP", "AND", "a1", "b1"}},
+		{"13: REPLCONF GETACK", []string{"REPLCONF", "GETACK", "*"}, true, []string{"REPLCONF", "GETACK", "*"}},
+		{"14: Binary key, match", []string{"SET", "a\x00\xff", "v"}, true, []string{"SET", "a\x00\xff", "v"}},
+		{"15: Binary key, no match", []string{"SET", "\x00a", "v"}, false, nil},
+		{"16: DEL, binary keys", []string{"DEL", "\xffa", "a\x00", "b\x00"}, true, []string{"DEL", "a\x00"}},
+		{"17: XGROUP, match", []string{"XGROUP", "CREATE", "a1", "g1", "$"}, true, []string{"XGROUP", "CREATE", "a1", "g1", "$"}},
+		{"18: XGROUP, no match", []string{"XGROUP", "DESTROY", "b1", "g1"}, false, nil},
+	}
+
+	for _, test := range tests {
+		command := &redisCommand{raw: encodeRedisCommand(test.command...), command: test.command}
+
+		keep := filterCommand(command, match)
+		if keep != test.keep {
+			t.Errorf("Decision doesn't match: %v != %v (test %s)", keep, test.keep, test.description)
+			continue
+		}
+
+		if !keep {
+			continue
+		}
+
+		if !reflect.DeepEqual(command.command, test.expected) {
+			t.Errorf("Command not equal to expected %#v != %#v (test %s)", command.command, test.expected, test.description)
+		}
+		if string(command.raw) != string(encodeRedisCommand(test.expected...)) {
+			t.Errorf("Raw command doesn't match command %#v (test %s)", string(command.raw), test.description)
+		}
+	}
+}
+
+func TestSelectedDB(t *testing.T) {
+	db, ok := selectedDB(&redisCommand{command: []string{"select", "5"}})
+	if !ok || db != 5 {
+		t.Errorf("SELECT not recognized: %d %v", db, ok)
+	}
+
+	_, ok = selectedDB(&redisCommand{command: []string{"SET", "5", "1"}})
+	if ok {
+		t.Errorf("SET shouldn't be recognized as SELECT")
+	}
+
+	if commandHasKeys(&redisCommand{command: []string{"SELECT", "5"}}) || !commandHasKeys(&redisCommand{command: []string{"SET", "5", "1"}}) {
+		t.Errorf("Commands with keys not recognized")
+	}
+}
+
+func TestCommandLogString(t *testing.T) {
+	tests := []struct {
+		description string
+		command     []string
+		logValues   bool
+		expected    string
+	}{
+		{"1: Value redacted", []string{"SET", "a1", "secret"}, false, "SET a1 <redacted>"},
+		{"2: MSET values redacted", []string{"MSET", "a1", "secret1", "a2", "secret2"}, false, "MSET a1 <redacted> a2 <redacted>"},
+		{"3: DEL keys only", []string{"DEL", "a1", "a2"}, false, "DEL a1 a2"},
+		{"4: Hash fields & values redacted", []string{"HSET", "a1", "email", "user@example.com"}, false, "HSET a1 <redacted> <redacted>"},
+		{"5: No keys", []string{"SELECT", "1"}, false, "SELECT 1"},
+		{"6: BITOP", []string{"BITOP", "AND", "a1", "b1"}, false, "BITOP <redacted> a1 b1"},
+		{"7: Values logged", []string{"SET", "a1", "secret"}, true, "SET a1 secret"},
+	}
+
+	for _, test := range tests {
+		result := commandLogString(&redisCommand{command: test.command}, test.logValues)
+		if result != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", test.expected, result, test.description)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package resharding
+
+import (
+	"testing"
+)
+
+func TestRedisCRC16(t *testing.T) {
+	hash := CRC16([]byte{'1', '2', '3', '4', '5', '6', '7', '8', '9'})
+	if hash != 0x31c3 {
+		t.Errorf("crc16 doesn't match: crc16(\"123456789\") = %#v != 0x31c3", hash)
+	}
+}
+
+func BenchmarkRedisCRC16(b *testing.B) {
+	data := []byte{'1', '2', '3', '4', '5', '6', '7', '8', '9'}
+	for i := 0; i < b.N; i++ {
+		CRC16(data)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package resharding
 
 // Redis version of CRC64
 
@@ -0,0 +1,24 @@
+package resharding_test
+
+import (
+	"log"
+	"net"
+
+	"github.com/admpub/redis-resharding-proxy/resharding"
+)
+
+func ExampleProxy() {
+	proxy := resharding.NewProxy("tcp", "redis1.srv:6379")
+	proxy.Matcher = resharding.PrefixMatcher{"session:", "cart:"}
+
+	ln, err := net.Listen("tcp", ":6380")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Serve returns after proxy.Close() is called
+	err = proxy.Serve(ln)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package resharding
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Request RDB from master with SYNC and wait for RDB bulk header, returns RDB size
+func (p *Proxy) requestRDB() (net.Conn, *bufio.Reader, int64, error) {
+	conn, reader, err := p.dialMaster(context.Background())
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	_, err = conn.Write(encodeRedisCommand("SYNC"))
+	if err != nil {
+		conn.Close()
+		return nil, nil, 0, fmt.Errorf("Failed to write data to master: %v", err)
+	}
+
+	logInfo("Starting SYNC")
+
+	for {
+		command, err := readRedisCommand(reader, p.MaxArgumentLength)
+		if err != nil {
+			conn.Close()
+			return nil, nil, 0, fmt.Errorf("Error while reading from master: %v", err)
+		}
+
+		if strings.HasPrefix(string(command.raw), "-") {
+			conn.Close()
+			return nil, nil, 0, fmt.Errorf("Master refused SYNC: %s", strings.TrimSpace(string(command.raw)))
+		}
+
+		if command.bulkSize > 0 {
+			logInfo("RDB size: %d", command.bulkSize)
+
+			err = p.checkRDBSize(command.bulkSize)
+			if err != nil {
+				conn.Close()
+				return nil, nil, 0, err
+			}
+
+			return conn, reader, command.bulkSize, nil
+		}
+
+		// newline keepalives & replies before RDB are skipped
+	}
+}
+
+// SaveRDB connects to master, runs SYNC and saves filtered RDB to file,
+// incremental command stream is not captured
+//
+// RDB is written to temporary file (in OutputTmpDir or next to path) which is synced and renamed to path
+// only once whole RDB is received and its checksum is verified, so partial RDB never appears at path
+//
+// With OutputCompress set to gzip file is gzip stream of filtered RDB, RDB checksum covers uncompressed data
+// as usual.
+func (p *Proxy) SaveRDB(path string) error {
+	if p.OutputCompress != "" && p.OutputCompress != "gzip" {
+		return fmt.Errorf("Unknown compression %q, expected gzip", p.OutputCompress)
+	}
+
+	conn, reader, size, err := p.requestRDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dir := p.OutputTmpDir
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+
+	file, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Unable to create RDB file: %v", err)
+	}
+
+	tmpPath := file.Name()
+	renamed := false
+	defer func() {
+		if !renamed {
+			file.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// filtered RDB (and its checksum) is produced before compression
+	var compressor *gzip.Writer
+	writer := bufio.NewWriterSize(file, bufSize)
+	if p.OutputCompress == "gzip" {
+		compressor = gzip.NewWriter(file)
+		writer = bufio.NewWriterSize(compressor, bufSize)
+	}
+
+	stats, _, err := p.filterRDB(reader, writer, size, false, "", true)
+	if err != nil {
+		return fmt.Errorf("Unable to extract RDB: %v", err)
+	}
+
+	err = writer.Flush()
+	if err == nil && compressor != nil {
+		err = compressor.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write RDB file: %v", err)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return fmt.Errorf("Failed to sync RDB file: %v", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to write RDB file: %v", err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("Failed to move RDB file into place: %v", err)
+	}
+	renamed = true
+
+	logInfo("Filtered RDB saved to %s", path)
+	p.rdbDone(stats)
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package resharding
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveRDB(t *testing.T) {
+	tests := []struct {
+		description string
+		rdb         string
+		maxRDBSize  int64
+		saved       bool
+	}{
+		{
+			description: "1: Valid RDB",
+			rdb:         RDBFile1,
+			saved:       true,
+		},
+		{
+			description: "2: Corrupted RDB",
+			rdb:         strings.Replace(RDBFile1, "lala", "lalo", 1),
+		},
+		{
+			description: "3: Truncated RDB",
+			rdb:         RDBFile1[:40],
+		},
+		{
+			description: "4: RDB at size limit",
+			rdb:         RDBFile1,
+			maxRDBSize:  int64(len(RDBFile1)),
+			saved:       true,
+		},
+		{
+			description: "5: RDB over size limit",
+			rdb:         RDBFile1,
+			maxRDBSize:  int64(len(RDBFile1)) - 1,
+		},
+	}
+
+	for _, test := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Unable to listen: %v", err)
+		}
+
+		go func(rdb string) {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			bufio.NewReader(conn).ReadString('\n')
+			fmt.Fprintf(conn, "$%d\r\n%s", len(RDBFile1), rdb)
+		}(test.rdb)
+
+		dir, err := ioutil.TempDir("", "resharding")
+		if err != nil {
+			t.Fatalf("Unable to create directory: %v", err)
+		}
+
+		p := NewProxy("tcp", ln.Addr().String())
+		p.MasterReadTimeout = time.Second
+		p.MaxRDBSize = test.maxRDBSize
+		path := filepath.Join(dir, "dump.rdb")
+
+		err = p.SaveRDB(path)
+		if (err == nil) != test.saved {
+			t.Errorf("Unexpected result %v (test %s)", err, test.description)
+		}
+
+		_, err = os.Stat(path)
+		if (err == nil) != test.saved {
+			t.Errorf("Output file should exist only after successful extraction (test %s)", test.description)
+		}
+
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) > 1 || (!test.saved && len(files) > 0) {
+			t.Errorf("Temporary file should be removed (test %s)", test.description)
+		}
+
+		ln.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSaveRDBCompressed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		fmt.Fprintf(conn, "$%d\r\n%s", len(RDBFile1), RDBFile1)
+	}()
+
+	dir, err := ioutil.TempDir("", "resharding")
+	if err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := NewProxy("tcp", ln.Addr().String())
+	p.MasterReadTimeout = time.Second
+	p.OutputCompress = "gzip"
+	path := filepath.Join(dir, "dump.rdb.gz")
+
+	err = p.SaveRDB(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unable to open output file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Output file isn't gzip stream: %v", err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Unable to decompress output file: %v", err)
+	}
+
+	// all keys are kept, so RDB (with its checksum) is unchanged
+	if string(data) != RDBFile1 {
+		t.Errorf("Output not equal to expected %#v != %#v", string(data), RDBFile1)
+	}
+
+	p.OutputCompress = "zip"
+	if err = p.SaveRDB(path); err == nil {
+		t.Errorf("Unknown compression should fail")
+	}
+}
+
+func TestRDBDone(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		fmt.Fprintf(conn, "$%d\r\n%s", len(RDBFile1), RDBFile1)
+	}()
+
+	dir, err := ioutil.TempDir("", "resharding")
+	if err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var done []RDBStats
+
+	p := NewProxy("tcp", ln.Addr().String())
+	p.MasterReadTimeout = time.Second
+	p.Matcher = PrefixMatcher{"a_"}
+	p.RDBDone = func(stats RDBStats) {
+		done = append(done, stats)
+	}
+
+	err = p.SaveRDB(filepath.Join(dir, "dump.rdb"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(done) != 1 {
+		t.Fatalf("RDBDone should be called once: %#v", done)
+	}
+	stats := done[0]
+	if stats.KeysKept != 2 || stats.KeysSkipped != 3 || stats.BytesIn != int64(len(RDBFile1)) {
+		t.Errorf("Statistics don't match: %#v", stats)
+	}
+	if stats.BytesOut <= 0 || stats.BytesOut >= stats.BytesIn {
+		t.Errorf("Filtered RDB should be smaller than source: %#v", stats)
+	}
+}
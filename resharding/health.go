@@ -0,0 +1,70 @@
+package resharding
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Health describes state of proxy reported by health endpoint
+type Health struct {
+	// Proxy accepts slave connections
+	Listening bool `json:"listening"`
+	// Number of connected slaves and of their master connections which are up
+	Slaves  int `json:"slaves"`
+	Masters int `json:"masters"`
+	// Every slave has its master connection up (false if there are no slaves)
+	MasterConnected bool `json:"master_connected"`
+	// Listening and no master connection is down or reconnecting
+	Healthy bool `json:"healthy"`
+}
+
+// Health returns current state of proxy
+func (p *Proxy) Health() Health {
+	p.sessionsLock.Lock()
+	health := Health{
+		Listening: len(p.listeners) > 0,
+		Slaves:    len(p.sessions),
+		Masters:   int(atomic.LoadInt32(&p.masters)),
+	}
+	p.sessionsLock.Unlock()
+
+	select {
+	case <-p.closed:
+		health.Listening = false
+	default:
+	}
+
+	health.MasterConnected = health.Masters > 0 && health.Masters >= health.Slaves
+	health.Healthy = health.Listening && (health.Slaves == 0 || health.MasterConnected)
+
+	return health
+}
+
+// HealthHandler responds with state of proxy as JSON, status is 200 if proxy is healthy and 503 otherwise,
+// it could be used as liveness/readiness probe
+func (p *Proxy) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	health := p.Health()
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(health)
+}
+
+// ServeHealth starts HTTP server for health endpoint of proxy
+func ServeHealth(addr string, p *Proxy) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", p.HealthHandler)
+
+	logInfo("Serving health at %s/health", addr)
+
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		logError("Unable to serve health: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package resharding
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+
+	check := func(description string, status int, expected Health) {
+		recorder := httptest.NewRecorder()
+		p.HealthHandler(recorder, httptest.NewRequest("GET", "/health", nil))
+
+		if recorder.Code != status {
+			t.Errorf("Status not equal to expected %d != %d (test %s)", recorder.Code, status, description)
+		}
+
+		var health Health
+		err := json.Unmarshal(recorder.Body.Bytes(), &health)
+		if err != nil {
+			t.Errorf("Unable to decode body: %v (test %s)", err, description)
+		} else if health != expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", health, expected, description)
+		}
+	}
+
+	check("1: Not listening", http.StatusServiceUnavailable, Health{})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.Serve(ln)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	check("2: No slaves", http.StatusOK, Health{Listening: true, Healthy: true})
+
+	server, client := net.Pipe()
+	defer client.Close()
+	p.sessionStarted(server)
+
+	check("3: Master is down", http.StatusServiceUnavailable, Health{Listening: true, Slaves: 1})
+
+	p.masters++
+	check("4: Master is connected", http.StatusOK, Health{Listening: true, Slaves: 1, Masters: 1, MasterConnected: true, Healthy: true})
+	p.masters--
+
+	p.sessionFinished(server)
+	p.Close()
+	<-done
+
+	check("5: Closed", http.StatusServiceUnavailable, Health{})
+}
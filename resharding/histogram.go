@@ -0,0 +1,127 @@
+package resharding
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Keys are grouped by prefix up to histogramSeparator, keys without separator are counted together
+const (
+	histogramSeparator = ":"
+	histogramNoPrefix  = "(no prefix)"
+)
+
+// prefixStats holds number & total size of keys sharing the same prefix
+type prefixStats struct {
+	prefix string
+	keys   int64
+	bytes  int64
+}
+
+// prefixHistogram collects distribution of key prefixes, only every sample-th key is accounted
+type prefixHistogram struct {
+	sample   int
+	seen     int64
+	prefixes map[string]*prefixStats
+}
+
+func newPrefixHistogram(sample int) *prefixHistogram {
+	if sample < 1 {
+		sample = 1
+	}
+	return &prefixHistogram{sample: sample, prefixes: make(map[string]*prefixStats)}
+}
+
+// Account RDB entry, used as RDBFilter entryDone callback
+func (histogram *prefixHistogram) add(entry RDBEntry, kept bool) {
+	histogram.seen++
+	if (histogram.seen-1)%int64(histogram.sample) != 0 {
+		return
+	}
+
+	prefix := histogramNoPrefix
+	if i := strings.Index(entry.Key, histogramSeparator); i >= 0 {
+		prefix = entry.Key[:i+len(histogramSeparator)]
+	}
+
+	stats := histogram.prefixes[prefix]
+	if stats == nil {
+		stats = &prefixStats{prefix: prefix}
+		histogram.prefixes[prefix] = stats
+	}
+
+	stats.keys++
+	stats.bytes += int64(entry.Size)
+}
+
+// Print top prefixes by number of keys and by total size, sampled values are scaled up
+func (histogram *prefixHistogram) print(w io.Writer, top int) {
+	stats := make([]*prefixStats, 0, len(histogram.prefixes))
+	for _, s := range histogram.prefixes {
+		stats = append(stats, s)
+	}
+
+	if top <= 0 || top > len(stats) {
+		top = len(stats)
+	}
+
+	scale := int64(histogram.sample)
+	if scale > 1 {
+		fmt.Fprintf(w, "Sampled 1 of %d keys, numbers are estimated\n", scale)
+	}
+	fmt.Fprintf(w, "Keys total:     %d\n", histogram.seen)
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].keys != stats[j].keys {
+			return stats[i].keys > stats[j].keys
+		}
+		return stats[i].prefix < stats[j].prefix
+	})
+
+	fmt.Fprintln(w, "Top prefixes by keys:")
+	for _, s := range stats[:top] {
+		fmt.Fprintf(w, "  %-24s %d\n", s.prefix, s.keys*scale)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].bytes != stats[j].bytes {
+			return stats[i].bytes > stats[j].bytes
+		}
+		return stats[i].prefix < stats[j].prefix
+	})
+
+	fmt.Fprintln(w, "Top prefixes by size:")
+	for _, s := range stats[:top] {
+		fmt.Fprintf(w, "  %-24s %d bytes\n", s.prefix, s.bytes*scale)
+	}
+}
+
+// Histogram connects to master, runs SYNC and writes top prefixes of all keys in RDB (by number
+// of keys and by size) to w, filter is not applied; every sample-th key is accounted
+func (p *Proxy) Histogram(w io.Writer, top, sample int) error {
+	conn, reader, _, err := p.requestRDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	histogram := newPrefixHistogram(sample)
+
+	// all entries are kept, so that size of every entry is known
+	filter := newRDBFilter(reader, ioutil.Discard, KeyFilter(func(string) bool { return true }), 0)
+	filter.entryDone = histogram.add
+	filter.verify = p.VerifyRDB
+	filter.strict = p.StrictRDB
+
+	err = filter.run()
+	if err != nil {
+		return fmt.Errorf("Unable to read RDB: %v", err)
+	}
+
+	histogram.print(w, top)
+
+	return nil
+}
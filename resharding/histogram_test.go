@@ -0,0 +1,53 @@
+package resharding
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrefixHistogram(t *testing.T) {
+	histogram := newPrefixHistogram(1)
+	histogram.add(RDBEntry{Key: "user:1", Type: "string", Size: 10}, true)
+	histogram.add(RDBEntry{Key: "user:2", Type: "string", Size: 10}, true)
+	histogram.add(RDBEntry{Key: "session:1", Type: "hash", Size: 100}, true)
+	histogram.add(RDBEntry{Key: "counter", Type: "string", Size: 5}, true)
+
+	if histogram.prefixes["user:"].keys != 2 || histogram.prefixes["user:"].bytes != 20 {
+		t.Errorf("Prefix stats don't match: %#v", histogram.prefixes["user:"])
+	}
+
+	if histogram.prefixes[histogramNoPrefix].keys != 1 {
+		t.Errorf("Keys without prefix should be counted together: %#v", histogram.prefixes)
+	}
+
+	var buf bytes.Buffer
+	histogram.print(&buf, 1)
+
+	expected := "Keys total:     4\n" +
+		"Top prefixes by keys:\n" +
+		"  user:                    2\n" +
+		"Top prefixes by size:\n" +
+		"  session:                 100 bytes\n"
+	if buf.String() != expected {
+		t.Errorf("Output not equal to expected %#v != %#v", buf.String(), expected)
+	}
+}
+
+func TestPrefixHistogramSample(t *testing.T) {
+	histogram := newPrefixHistogram(2)
+	for i := 0; i < 10; i++ {
+		histogram.add(RDBEntry{Key: "user:1", Type: "string", Size: 10}, true)
+	}
+
+	if histogram.seen != 10 || histogram.prefixes["user:"].keys != 5 {
+		t.Errorf("Every second key should be accounted: %d seen, %#v", histogram.seen, histogram.prefixes["user:"])
+	}
+
+	var buf bytes.Buffer
+	histogram.print(&buf, 10)
+
+	if !strings.Contains(buf.String(), "  user:                    10\n") || !strings.Contains(buf.String(), "100 bytes") {
+		t.Errorf("Sampled numbers should be scaled: %s", buf.String())
+	}
+}
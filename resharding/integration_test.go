@@ -0,0 +1,263 @@
+//go:build integration
+// +build integration
+
+package resharding
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Integration test runs the whole flow against real Redis: go test -tags integration ./resharding,
+// redis-server should be in PATH
+
+// redisServer is redis-server process started for the test
+type redisServer struct {
+	cmd  *exec.Cmd
+	dir  string
+	addr string
+}
+
+func startRedisServer(t *testing.T) *redisServer {
+	path, err := exec.LookPath("redis-server")
+	if err != nil {
+		t.Skip("redis-server is not found in PATH")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to find free port: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+
+	server := &redisServer{dir: dir, addr: fmt.Sprintf("127.0.0.1:%d", port)}
+	server.cmd = exec.Command(path, "--port", strconv.Itoa(port), "--bind", "127.0.0.1", "--dir", dir,
+		"--save", "", "--appendonly", "no", "--repl-diskless-sync", "no")
+	server.cmd.Stdout, server.cmd.Stderr = os.Stderr, os.Stderr
+
+	err = server.cmd.Start()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Unable to start redis-server: %v", err)
+	}
+
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
+		client, err := dialRedisClient(server.addr)
+		if err != nil {
+			continue
+		}
+		err = client.do("PING")
+		client.Close()
+		if err == nil {
+			return server
+		}
+	}
+
+	server.stop()
+	t.Fatalf("redis-server at %s hasn't started", server.addr)
+	return nil
+}
+
+func (server *redisServer) stop() {
+	server.cmd.Process.Kill()
+	server.cmd.Wait()
+	os.RemoveAll(server.dir)
+}
+
+// redisClient sends commands which get single line replies (status or integer)
+type redisClient struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func dialRedisClient(addr string) (*redisClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &redisClient{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (client *redisClient) do(args ...string) error {
+	_, err := client.Write(encodeRedisCommand(args...))
+	if err != nil {
+		return err
+	}
+
+	reply, err := readRedisCommand(client.reader, DefaultMaxArgumentLength)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(reply.reply, "-") {
+		return fmt.Errorf("Command %s failed: %s", args[0], reply.reply)
+	}
+	return nil
+}
+
+// Fill database with keys of all types in compact & generic encodings, prefixed with a_ and b_,
+// returns types of all keys
+func populateRedis(t *testing.T, client *redisClient) map[string]string {
+	types := make(map[string]string)
+
+	for _, prefix := range []string{"a_", "b_"} {
+		commands := [][]string{
+			{"SET", prefix + "string", "value"},
+			{"SET", prefix + "int", "12345"},
+			{"RPUSH", prefix + "list", "x", "y", "z"},
+			{"HSET", prefix + "hash_small", "f1", "v1", "f2", "v2"},
+			{"SADD", prefix + "set_int", "1", "2", "3"},
+			{"SADD", prefix + "set_small", "x", "y"},
+			{"ZADD", prefix + "zset_small", "1", "m1", "2.5", "m2"},
+		}
+
+		hash := []string{"HSET", prefix + "hash_large"}
+		set := []string{"SADD", prefix + "set_large"}
+		zset := []string{"ZADD", prefix + "zset_large"}
+		for i := 0; i < 600; i++ {
+			hash = append(hash, fmt.Sprintf("field%d", i), strings.Repeat("v", i%100))
+			set = append(set, strconv.Itoa(i))
+			zset = append(zset, strconv.Itoa(i), fmt.Sprintf("member%d", i))
+		}
+		commands = append(commands, hash, set, zset)
+
+		for _, command := range commands {
+			err := client.do(command...)
+			if err != nil {
+				t.Fatalf("Unable to populate Redis: %v", err)
+			}
+		}
+
+		for key, kind := range map[string]string{
+			"string": "string", "int": "string", "list": "list", "hash_small": "hash", "hash_large": "hash",
+			"set_int": "set", "set_small": "set", "set_large": "set", "zset_small": "zset", "zset_large": "zset",
+		} {
+			types[prefix+key] = kind
+		}
+	}
+
+	return types
+}
+
+func TestIntegrationSync(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Integration test is skipped in short mode")
+	}
+
+	server := startRedisServer(t)
+	defer server.stop()
+
+	client, err := dialRedisClient(server.addr)
+	if err != nil {
+		t.Fatalf("Unable to connect to Redis: %v", err)
+	}
+	defer client.Close()
+
+	types := populateRedis(t, client)
+
+	p := NewProxy("tcp", server.addr)
+	p.Matcher = PrefixMatcher{"a_"}
+	p.MasterRetryMax = 0
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	go p.Serve(ln)
+	defer p.Close()
+
+	slave, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect to proxy: %v", err)
+	}
+	defer slave.Close()
+	slave.SetDeadline(time.Now().Add(30 * time.Second))
+
+	_, err = slave.Write(encodeRedisCommand("SYNC"))
+	if err != nil {
+		t.Fatalf("Unable to send SYNC: %v", err)
+	}
+
+	reader := bufio.NewReader(slave)
+
+	// master sends newlines while RDB is being saved
+	var header *redisCommand
+	for header == nil || header.bulkSize <= 0 {
+		header, err = readRedisCommand(reader, DefaultMaxArgumentLength)
+		if err != nil {
+			t.Fatalf("Unable to read RDB header: %v", err)
+		}
+	}
+
+	rdb := make([]byte, header.bulkSize)
+	_, err = io.ReadFull(reader, rdb)
+	if err != nil {
+		t.Fatalf("Unable to read RDB: %v", err)
+	}
+
+	kept := make(map[string]string)
+	err = ExtractRDB(bufio.NewReader(bytes.NewReader(rdb)), ioutil.Discard, func(entry RDBEntry) bool {
+		kept[entry.Key] = entry.Type
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Unable to parse filtered RDB: %v", err)
+	}
+
+	expected := make(map[string]string)
+	for key, kind := range types {
+		if strings.HasPrefix(key, "a_") {
+			expected[key] = kind
+		}
+	}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Keys in RDB don't match: %v != %v", kept, expected)
+	}
+
+	for _, command := range [][]string{
+		{"SET", "a_new", "1"},
+		{"SET", "b_new", "2"},
+		{"HSET", "b_hash_small", "f3", "v3"},
+		{"DEL", "a_string", "b_string"},
+	} {
+		err = client.do(command...)
+		if err != nil {
+			t.Fatalf("Unable to write to Redis: %v", err)
+		}
+	}
+
+	expectedCommands := [][]string{{"SET", "a_new", "1"}, {"DEL", "a_string"}}
+
+	var commands [][]string
+	for len(commands) < len(expectedCommands) {
+		command, err := readRedisCommand(reader, DefaultMaxArgumentLength)
+		if err != nil {
+			t.Fatalf("Unable to read command stream: %v (got %v)", err, commands)
+		}
+		if command.command == nil || len(command.command) > 0 && !commandHasKeys(command) {
+			// SELECT, PING & keepalives
+			continue
+		}
+		commands = append(commands, command.command)
+	}
+
+	if !reflect.DeepEqual(commands, expectedCommands) {
+		t.Errorf("Commands don't match: %v != %v", commands, expectedCommands)
+	}
+}
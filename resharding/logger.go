@@ -0,0 +1,114 @@
+package resharding
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// LogLevel is verbosity of logging, messages above current level are not logged
+type LogLevel int
+
+const (
+	levelError LogLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var logLevelNames = []string{"error", "warn", "info", "debug"}
+
+var (
+	currentLogLevel = levelInfo
+	logJSON         bool
+)
+
+// ParseLogLevel parses log level name
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown log level %q, should be one of %s", name, strings.Join(logLevelNames, ", "))
+}
+
+// SetupLogging configures log level & output format
+func SetupLogging(level LogLevel, json bool) {
+	currentLogLevel = level
+	logJSON = json
+
+	if logJSON {
+		log.SetFlags(0)
+	}
+}
+
+// Check whether messages of level would be logged
+func logEnabled(level LogLevel) bool {
+	return level <= currentLogLevel
+}
+
+// Format log entry as JSON line
+func formatJSONLog(level LogLevel, message string) string {
+	entry, _ := json.Marshal(struct {
+		Time    string `json:"time"`
+		Level   string `json:"level"`
+		Message string `json:"msg"`
+	}{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   logLevelNames[level],
+		Message: message,
+	})
+
+	return string(entry)
+}
+
+func logf(level LogLevel, format string, args ...interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+
+	if logJSON {
+		log.Println(formatJSONLog(level, message))
+	} else {
+		log.Printf("[%s] %s\n", strings.ToUpper(logLevelNames[level]), message)
+	}
+}
+
+func logError(format string, args ...interface{}) {
+	logf(levelError, format, args...)
+}
+
+func logWarn(format string, args ...interface{}) {
+	logf(levelWarn, format, args...)
+}
+
+func logInfo(format string, args ...interface{}) {
+	logf(levelInfo, format, args...)
+}
+
+func logDebug(format string, args ...interface{}) {
+	logf(levelDebug, format, args...)
+}
+
+// LogInfo logs informational message, it is exported for the command line wrapper & embedding programs
+func LogInfo(format string, args ...interface{}) {
+	logf(levelInfo, format, args...)
+}
+
+// LogError logs error message
+func LogError(format string, args ...interface{}) {
+	logf(levelError, format, args...)
+}
+
+// LogFatal logs error and exits
+func LogFatal(format string, args ...interface{}) {
+	logf(levelError, format, args...)
+	os.Exit(1)
+}
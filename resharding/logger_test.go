@@ -0,0 +1,31 @@
+package resharding
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("DEBUG")
+	if err != nil || level != levelDebug {
+		t.Errorf("Log level doesn't match: %v (%v)", level, err)
+	}
+
+	_, err = ParseLogLevel("verbose")
+	if err == nil {
+		t.Errorf("Should have failed on unknown level")
+	}
+}
+
+func TestFormatJSONLog(t *testing.T) {
+	var entry map[string]string
+
+	err := json.Unmarshal([]byte(formatJSONLog(levelWarn, "RDB size: \"1\"")), &entry)
+	if err != nil {
+		t.Fatalf("Unable to parse log entry: %v", err)
+	}
+
+	if entry["level"] != "warn" || entry["msg"] != "RDB size: \"1\"" || entry["time"] == "" {
+		t.Errorf("Log entry doesn't match: %#v", entry)
+	}
+}
@@ -0,0 +1,81 @@
+package resharding
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+)
+
+// candidateRefusedError is returned by master session when one of several candidate masters
+// refuses replication, next attempt starts with the following candidate
+type candidateRefusedError struct {
+	candidate int
+	reply     string
+}
+
+func (e *candidateRefusedError) Error() string {
+	return fmt.Sprintf("Master candidate refused replication: %s", e.reply)
+}
+
+// Addresses of candidate masters, single MasterAddr unless Masters are set
+func (p *Proxy) masterAddrs() []string {
+	if len(p.Masters) == 0 {
+		return []string{p.MasterAddr}
+	}
+	return p.Masters
+}
+
+// Order in which candidates are tried: starting with first one and wrapping around,
+// or random with MasterJitter
+func (p *Proxy) candidateOrder(first int) []int {
+	count := len(p.masterAddrs())
+	if p.MasterJitter {
+		return rand.Perm(count)
+	}
+
+	order := make([]int, count)
+	for i := range order {
+		order[i] = (first + i) % count
+	}
+	return order
+}
+
+// Connect to the first candidate master which accepts connection, starting with candidate first;
+// returns index of candidate connected to
+func (p *Proxy) dialMasterFrom(ctx context.Context, first int) (net.Conn, *bufio.Reader, int, error) {
+	addrs := p.masterAddrs()
+
+	var err error
+	for _, candidate := range p.candidateOrder(first) {
+		var (
+			conn   net.Conn
+			reader *bufio.Reader
+		)
+		conn, reader, err = p.dialMasterAddr(ctx, addrs[candidate])
+		if err == nil {
+			p.currentMaster.Store(addrs[candidate])
+			metricMasterCandidate.Set(int64(candidate))
+			if len(addrs) > 1 {
+				logInfo("Connected to master candidate %s", addrs[candidate])
+			}
+			return conn, reader, candidate, nil
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+		if len(addrs) > 1 {
+			logWarn("Master candidate %s failed: %v", addrs[candidate], err)
+		}
+	}
+
+	return nil, nil, 0, err
+}
+
+// CurrentMaster returns address of master proxy has connected to most recently, empty if none yet
+func (p *Proxy) CurrentMaster() string {
+	addr, _ := p.currentMaster.Load().(string)
+	return addr
+}
@@ -0,0 +1,165 @@
+package resharding
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCandidateOrder(t *testing.T) {
+	tests := []struct {
+		description string
+		masters     []string
+		first       int
+		expected    []int
+	}{
+		{"1: Single master", nil, 0, []int{0}},
+		{"2: Candidates in order", []string{"a:1", "b:1", "c:1"}, 0, []int{0, 1, 2}},
+		{"3: Starting with next candidate", []string{"a:1", "b:1", "c:1"}, 2, []int{2, 0, 1}},
+		{"4: Wrapping around", []string{"a:1", "b:1", "c:1"}, 3, []int{0, 1, 2}},
+	}
+
+	for _, test := range tests {
+		p := NewProxy("tcp", "localhost:6379")
+		p.Masters = test.masters
+
+		order := p.candidateOrder(test.first)
+		if !reflect.DeepEqual(order, test.expected) {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", order, test.expected, test.description)
+		}
+	}
+
+	p := NewProxy("tcp", "localhost:6379")
+	p.Masters = []string{"a:1", "b:1", "c:1"}
+	p.MasterJitter = true
+
+	order := p.candidateOrder(1)
+	sort.Ints(order)
+	if !reflect.DeepEqual(order, []int{0, 1, 2}) {
+		t.Errorf("Random order should contain every candidate once: %#v", order)
+	}
+}
+
+// Address nothing listens on
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestDialMasterCandidates(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	p := NewProxy("tcp", "")
+	p.Masters = []string{closedAddr(t), ln.Addr().String()}
+
+	conn, _, candidate, err := p.dialMasterFrom(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conn.Close()
+
+	if candidate != 1 || p.CurrentMaster() != ln.Addr().String() || metricMasterCandidate.Value() != 1 {
+		t.Errorf("Second candidate should be connected to: %d %s", candidate, p.CurrentMaster())
+	}
+
+	p.Masters = []string{closedAddr(t), closedAddr(t)}
+	if _, _, _, err = p.dialMasterFrom(context.Background(), 0); err == nil {
+		t.Errorf("Should have failed when no candidate is reachable")
+	}
+}
+
+func TestCandidateRefused(t *testing.T) {
+	replica, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer replica.Close()
+
+	master, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer master.Close()
+
+	go func() {
+		conn, err := replica.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("-NOMASTERLINK Can't SYNC while not connected with my master\r\n"))
+	}()
+
+	rdb := "REDIS0006\xfe\x00\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+	go func() {
+		conn, err := master.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		fmt.Fprintf(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n$%d\r\n%s", len(rdb), rdb)
+	}()
+
+	p := NewProxy("tcp", "")
+	p.Masters = []string{replica.Addr().String(), master.Addr().String()}
+	p.MasterRetryMax = 1
+	p.MasterRetryInterval = time.Millisecond
+
+	server, client := net.Pipe()
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	slavechannel := make(chan []byte, channelBuffer)
+	masterchannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	request := &syncRequest{}
+	request.set(encodeRedisCommand("PSYNC", "?", "-1"))
+	masterchannel <- request.get()
+
+	p.masterConnection(ctx, ioutil.NopCloser(nil), output, slavechannel, masterchannel, request, &replicationOffset{})
+	close(slavechannel)
+	<-output.done
+	server.Close()
+
+	data := <-received
+	if strings.Contains(data, "NOMASTERLINK") || !strings.HasPrefix(data, "+FULLRESYNC") {
+		t.Errorf("Slave should get replication from second candidate only: %q", data)
+	}
+	if p.CurrentMaster() != master.Addr().String() {
+		t.Errorf("Current master doesn't match: %s != %s", p.CurrentMaster(), master.Addr())
+	}
+}
@@ -0,0 +1,175 @@
+package resharding
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// KeyMatcher decides whether key should be passed through to slave
+//
+// Key is passed as raw bytes of Redis key (Go string is binary-safe), no decoding is done,
+// so keys with null bytes or invalid UTF-8 are matched as is
+type KeyMatcher interface {
+	Match(key string) bool
+}
+
+// RegexpMatcher matches key if any of regular expressions matches
+//
+// Matching string is the same as matching []byte with regexp: key is treated as UTF-8, every
+// byte of invalid sequence matches as U+FFFD (\x{FFFD}), null byte could be matched with \x00
+type RegexpMatcher []*regexp.Regexp
+
+func (m RegexpMatcher) Match(key string) bool {
+	for _, re := range m {
+		if re.FindStringIndex(key) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CompileRegexps compiles regular expressions into RegexpMatcher, key matches if any part of it matches
+func CompileRegexps(patterns []string) (RegexpMatcher, error) {
+	return compileRegexps(patterns, "%s")
+}
+
+// CompileFullRegexps compiles regular expressions into RegexpMatcher which matches only keys matching
+// some expression as a whole, as if it was anchored with ^(...)$
+func CompileFullRegexps(patterns []string) (RegexpMatcher, error) {
+	return compileRegexps(patterns, "^(?:%s)$")
+}
+
+// Compile regular expressions, every pattern is wrapped according to format
+func compileRegexps(patterns []string, format string) (RegexpMatcher, error) {
+	var result RegexpMatcher
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(fmt.Sprintf(format, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", pattern, err)
+		}
+		result = append(result, re)
+	}
+
+	return result, nil
+}
+
+// PrefixMatcher matches key if it starts with any of prefixes
+type PrefixMatcher []string
+
+func (m PrefixMatcher) Match(key string) bool {
+	for _, prefix := range m {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SlotMatcher matches key if its hash slot falls into any of the ranges
+type SlotMatcher []IntRange
+
+func (m SlotMatcher) Match(key string) bool {
+	return RangesContain(m, KeyHashSlot(key))
+}
+
+// HashSlotMatcher matches key if its slot, computed with Hash modulo Slots, falls into any of the ranges;
+// with Ranges of single slot N and Slots set to total it keeps shard N of total
+type HashSlotMatcher struct {
+	Ranges []IntRange
+	Slots  int
+	Hash   HashFunc
+}
+
+func (m HashSlotMatcher) Match(key string) bool {
+	return RangesContain(m.Ranges, KeySlot(key, m.Hash, m.Slots))
+}
+
+// KeySetMatcher matches key if it is in the set, lookup doesn't depend on size of the set
+type KeySetMatcher map[string]struct{}
+
+func (m KeySetMatcher) Match(key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// ReadKeySet reads keys (one per line) into KeySetMatcher, encoding of lines is plain, base64 or hex,
+// binary keys (e.g. with line breaks) need one of the latter; empty lines are skipped
+func ReadKeySet(r io.Reader, encoding string) (KeySetMatcher, error) {
+	var decode func(line string) (string, error)
+
+	switch encoding {
+	case "plain":
+		decode = func(line string) (string, error) { return line, nil }
+	case "base64":
+		decode = func(line string) (string, error) {
+			key, err := base64.StdEncoding.DecodeString(line)
+			return string(key), err
+		}
+	case "hex":
+		decode = func(line string) (string, error) {
+			key, err := hex.DecodeString(line)
+			return string(key), err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown key encoding %q, expected plain, base64 or hex", encoding)
+	}
+
+	result := make(KeySetMatcher)
+	reader := bufio.NewReader(r)
+
+	for number := 1; ; number++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("Failed to read keys: %v", err)
+		}
+
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line != "" {
+			key, decodeErr := decode(line)
+			if decodeErr != nil {
+				return nil, fmt.Errorf("Wrong key at line %d: %v", number, decodeErr)
+			}
+			result[key] = struct{}{}
+		}
+
+		if err == io.EOF {
+			return result, nil
+		}
+	}
+}
+
+// AllMatcher matches key if all of matchers match, empty AllMatcher matches any key
+type AllMatcher []KeyMatcher
+
+func (m AllMatcher) Match(key string) bool {
+	for _, matcher := range m {
+		if !matcher.Match(key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ExcludeMatcher matches key if include matcher matches and exclude matcher doesn't,
+// so exclude takes precedence when key matches both
+type ExcludeMatcher struct {
+	Include KeyMatcher
+	Exclude KeyMatcher
+}
+
+func (m ExcludeMatcher) Match(key string) bool {
+	if m.Exclude.Match(key) {
+		return false
+	}
+
+	return m.Include.Match(key)
+}
@@ -0,0 +1,173 @@
+package resharding
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestKeyMatchers(t *testing.T) {
+	tests := []struct {
+		description string
+		matcher     KeyMatcher
+		key         string
+		expected    bool
+	}{
+		{"1: Regexp, first", RegexpMatcher{regexp.MustCompile("^session:"), regexp.MustCompile("^cart:")}, "session:1", true},
+		{"2: Regexp, second", RegexpMatcher{regexp.MustCompile("^session:"), regexp.MustCompile("^cart:")}, "cart:1", true},
+		{"3: Regexp, none", RegexpMatcher{regexp.MustCompile("^session:"), regexp.MustCompile("^cart:")}, "user:session:1", false},
+		{"4: Prefix", PrefixMatcher{"session:", "cart:"}, "cart:1", true},
+		{"5: Prefix, none", PrefixMatcher{"session:", "cart:"}, "user:cart:1", false},
+		{"6: Slot", SlotMatcher{{12182, 12182}}, "foo", true},
+		{"7: Slot, none", SlotMatcher{{12182, 12182}}, "bar", false},
+		{"8: All, empty", AllMatcher{}, "foo", true},
+		{"9: All", AllMatcher{PrefixMatcher{"f"}, SlotMatcher{{0, 16383}}}, "foo", true},
+		{"10: All, one fails", AllMatcher{PrefixMatcher{"f"}, SlotMatcher{{0, 100}}}, "foo", false},
+		{"11: Exclude only", ExcludeMatcher{AllMatcher{}, PrefixMatcher{"tmp:"}}, "user:1", true},
+		{"12: Exclude only, excluded", ExcludeMatcher{AllMatcher{}, PrefixMatcher{"tmp:"}}, "tmp:1", false},
+		{"13: Include & exclude, both match", ExcludeMatcher{PrefixMatcher{"user:"}, RegexpMatcher{regexp.MustCompile(":tmp$")}}, "user:1:tmp", false},
+		{"14: Include & exclude, include matches", ExcludeMatcher{PrefixMatcher{"user:"}, RegexpMatcher{regexp.MustCompile(":tmp$")}}, "user:1", true},
+		{"15: Include & exclude, none match", ExcludeMatcher{PrefixMatcher{"user:"}, RegexpMatcher{regexp.MustCompile(":tmp$")}}, "cart:1", false},
+		{"16: Regexp, null byte", RegexpMatcher{regexp.MustCompile(`^user\x00:`)}, "user\x00:1", true},
+		{"17: Regexp, null byte, none", RegexpMatcher{regexp.MustCompile(`^user:`)}, "user\x00:1", false},
+		{"18: Regexp, invalid UTF-8", RegexpMatcher{regexp.MustCompile(`^\x{FFFD}{2}:`)}, "\xff\xfe:1", true},
+		{"19: Prefix, binary", PrefixMatcher{"\xff\x00"}, "\xff\x00key", true},
+		{"20: Slot, null byte", SlotMatcher{{KeyHashSlot("a\x00b"), KeyHashSlot("a\x00b")}}, "a\x00b", true},
+		{"21: Hash slot, cluster", HashSlotMatcher{[]IntRange{{12182, 12182}}, 16384, SlotHashes["crc16"]}, "foo", true},
+		{"22: Shard", HashSlotMatcher{[]IntRange{{2, 2}}, 4, SlotHashes["crc16"]}, "foo", true},
+		{"23: Shard, other", HashSlotMatcher{[]IntRange{{1, 1}}, 4, SlotHashes["crc16"]}, "foo", false},
+		{"24: Shard, hash tag", HashSlotMatcher{[]IntRange{{2, 2}}, 4, SlotHashes["crc16"]}, "{foo}:bar", true},
+		{"25: Shard, crc32", HashSlotMatcher{[]IntRange{{9, 9}}, 10, SlotHashes["crc32"]}, "foo", true},
+	}
+
+	for _, test := range tests {
+		if test.matcher.Match(test.key) != test.expected {
+			t.Errorf("Match for key %q should be %v (test %s)", test.key, test.expected, test.description)
+		}
+	}
+}
+
+func TestRegexpMatcherBinaryKeys(t *testing.T) {
+	patterns := []string{`^user\x00`, `\x{FFFD}$`, `^[a-z]+:`, `.`, `^$`}
+	keys := []string{"user\x00:1", "user:\xff", "\x00\x00", "", "\xc3\xa9t\xc3\xa9:1", "\xc3"}
+
+	for _, pattern := range patterns {
+		re := regexp.MustCompile(pattern)
+		matcher := RegexpMatcher{re}
+
+		for _, key := range keys {
+			// matching key as string should be the same as matching raw bytes
+			if matcher.Match(key) != re.Match([]byte(key)) {
+				t.Errorf("Match for key %q with %q differs from matching bytes", key, pattern)
+			}
+		}
+	}
+}
+
+func TestCompileFullRegexps(t *testing.T) {
+	tests := []struct {
+		description string
+		patterns    []string
+		key         string
+		substring   bool
+		full        bool
+	}{
+		{"1: Whole key", []string{"user"}, "user", true, true},
+		{"2: Part of the key", []string{"user"}, "superuser:1", true, false},
+		{"3: Alternation is grouped", []string{"a|b:1"}, "a:1", true, false},
+		{"4: Alternation, whole key", []string{"a|b:1"}, "b:1", true, true},
+		{"5: Already anchored", []string{"^user:[0-9]+$"}, "user:12", true, true},
+		{"6: Several patterns", []string{"cart", "user:.*"}, "user:1", true, true},
+	}
+
+	for _, test := range tests {
+		substring, err := CompileRegexps(test.patterns)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (test %s)", err, test.description)
+		}
+		full, err := CompileFullRegexps(test.patterns)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (test %s)", err, test.description)
+		}
+
+		if substring.Match(test.key) != test.substring {
+			t.Errorf("Substring match for key %q should be %v (test %s)", test.key, test.substring, test.description)
+		}
+		if full.Match(test.key) != test.full {
+			t.Errorf("Full match for key %q should be %v (test %s)", test.key, test.full, test.description)
+		}
+	}
+
+	if _, err := CompileFullRegexps([]string{"a("}); err == nil || !strings.Contains(err.Error(), `"a("`) {
+		t.Errorf("Error should mention original pattern: %v", err)
+	}
+}
+
+func TestReadKeySet(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		encoding    string
+		expected    KeySetMatcher
+		shouldFail  bool
+	}{
+		{
+			description: "1: Plain keys",
+			input:       "user:1\nuser:2\r\n\ncart:1",
+			encoding:    "plain",
+			expected:    KeySetMatcher{"user:1": {}, "user:2": {}, "cart:1": {}},
+		},
+		{
+			description: "2: Base64 keys",
+			input:       "dXNlcjox\nAGsKZXk=\n",
+			encoding:    "base64",
+			expected:    KeySetMatcher{"user:1": {}, "\x00k\ney": {}},
+		},
+		{
+			description: "3: Hex keys",
+			input:       "757365723a31\n00ff\n",
+			encoding:    "hex",
+			expected:    KeySetMatcher{"user:1": {}, "\x00\xff": {}},
+		},
+		{
+			description: "4: Empty file",
+			input:       "",
+			encoding:    "plain",
+			expected:    KeySetMatcher{},
+		},
+		{
+			description: "5: Wrong hex",
+			input:       "757365723a31\nzz\n",
+			encoding:    "hex",
+			shouldFail:  true,
+		},
+		{
+			description: "6: Unknown encoding",
+			input:       "user:1\n",
+			encoding:    "rot13",
+			shouldFail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		keys, err := ReadKeySet(strings.NewReader(test.input), test.encoding)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("Should have failed (test %s)", test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+		} else if !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", keys, test.expected, test.description)
+		}
+	}
+
+	keys := KeySetMatcher{"user:1": {}}
+	if !keys.Match("user:1") || keys.Match("user:10") || keys.Match("superuser:1") {
+		t.Errorf("Key set should match only exact keys")
+	}
+}
@@ -0,0 +1,220 @@
+package resharding
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is a single counter or gauge exposed in Prometheus text format
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value int64
+	// gauge which is sampled when read instead of being updated
+	sample func() int64
+}
+
+func (m *metric) Add(delta int64) {
+	atomic.AddInt64(&m.value, delta)
+}
+
+func (m *metric) Inc() {
+	m.Add(1)
+}
+
+func (m *metric) Dec() {
+	m.Add(-1)
+}
+
+func (m *metric) Set(value int64) {
+	atomic.StoreInt64(&m.value, value)
+}
+
+func (m *metric) Value() int64 {
+	if m.sample != nil {
+		return m.sample()
+	}
+	return atomic.LoadInt64(&m.value)
+}
+
+// channelQueues tracks channels of running sessions, so that number of queued messages
+// could be sampled
+type channelQueues struct {
+	sync.Mutex
+	channels map[chan []byte]struct{}
+}
+
+func newChannelQueues() *channelQueues {
+	return &channelQueues{channels: make(map[chan []byte]struct{})}
+}
+
+func (q *channelQueues) add(channel chan []byte) {
+	q.Lock()
+	q.channels[channel] = struct{}{}
+	q.Unlock()
+}
+
+func (q *channelQueues) remove(channel chan []byte) {
+	q.Lock()
+	delete(q.channels, channel)
+	q.Unlock()
+}
+
+// Total number of messages queued in all channels
+func (q *channelQueues) queued() int64 {
+	q.Lock()
+	defer q.Unlock()
+
+	total := 0
+	for channel := range q.channels {
+		total += len(channel)
+	}
+	return int64(total)
+}
+
+var (
+	// queues of commands from master to slaves and from slaves to masters
+	slaveQueues  = newChannelQueues()
+	masterQueues = newChannelQueues()
+)
+
+var (
+	metricMasterCommands = &metric{
+		name: "redis_resharding_master_commands_total",
+		help: "Commands read from master.",
+		kind: "counter",
+	}
+	metricForwardedCommands = &metric{
+		name: "redis_resharding_forwarded_commands_total",
+		help: "Commands forwarded to slave.",
+		kind: "counter",
+	}
+	metricFilteredCommands = &metric{
+		name: "redis_resharding_filtered_commands_total",
+		help: "Commands filtered out.",
+		kind: "counter",
+	}
+	metricRDBBytes = &metric{
+		name: "redis_resharding_rdb_bytes_total",
+		help: "RDB bytes transferred to slave.",
+		kind: "counter",
+	}
+	metricKeysKept = &metric{
+		name: "redis_resharding_rdb_keys_kept_total",
+		help: "Keys kept while filtering RDB.",
+		kind: "counter",
+	}
+	metricKeysSkipped = &metric{
+		name: "redis_resharding_rdb_keys_skipped_total",
+		help: "Keys skipped while filtering RDB.",
+		kind: "counter",
+	}
+	metricSlaves = &metric{
+		name: "redis_resharding_connected_slaves",
+		help: "Currently connected slaves.",
+		kind: "gauge",
+	}
+	metricMasterOffset = &metric{
+		name: "redis_resharding_master_repl_offset",
+		help: "Replication offset of stream read from master.",
+		kind: "gauge",
+	}
+	metricForwardedOffset = &metric{
+		name: "redis_resharding_forwarded_repl_offset",
+		help: "Replication offset of stream forwarded to slave.",
+		kind: "gauge",
+	}
+	metricMasterCandidate = &metric{
+		name: "redis_resharding_master_candidate",
+		help: "Index of candidate master (in order of -master options, from 0) connected to most recently.",
+		kind: "gauge",
+	}
+	metricSlaveQueued = &metric{
+		name:   "redis_resharding_slave_queue_length",
+		help:   "Messages queued for writing to slaves, summed over sessions.",
+		kind:   "gauge",
+		sample: slaveQueues.queued,
+	}
+	metricMasterQueued = &metric{
+		name:   "redis_resharding_master_queue_length",
+		help:   "Messages queued for writing to masters, summed over sessions.",
+		kind:   "gauge",
+		sample: masterQueues.queued,
+	}
+	metricSessionPanics = &metric{
+		name: "redis_resharding_session_panics_total",
+		help: "Slave sessions torn down because of panic.",
+		kind: "counter",
+	}
+
+	metrics = []*metric{
+		metricMasterCommands,
+		metricForwardedCommands,
+		metricFilteredCommands,
+		metricRDBBytes,
+		metricKeysKept,
+		metricKeysSkipped,
+		metricSlaves,
+		metricMasterOffset,
+		metricForwardedOffset,
+		metricMasterCandidate,
+		metricSlaveQueued,
+		metricMasterQueued,
+		metricSessionPanics,
+	}
+)
+
+// Key predicate for FilterRDB which counts kept and skipped keys
+func (p *Proxy) countingKeyMatches(key string) bool {
+	if p.Matcher.Match(key) {
+		metricKeysKept.Inc()
+		logDebug("RDB key %q kept", key)
+		return true
+	}
+
+	metricKeysSkipped.Inc()
+	logDebug("RDB key %q skipped", key)
+	return false
+}
+
+// Entry predicate for FilterRDB which counts kept and skipped keys, entry should be big enough
+// and its key should match
+func (p *Proxy) countingEntryMatches(entry RDBEntry) bool {
+	if !p.bigEnough(entry) {
+		metricKeysSkipped.Inc()
+		logDebug("RDB key %q skipped, value is below size threshold", entry.Key)
+		return false
+	}
+
+	return p.countingKeyMatches(entry.Key)
+}
+
+// HTTP handler exposing metrics in Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.Value())
+	}
+}
+
+// ServeMetrics starts HTTP server for metrics endpoint, health endpoint of proxy
+// is served too if proxy is given
+func ServeMetrics(addr string, p *Proxy) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	if p != nil {
+		mux.HandleFunc("/health", p.HealthHandler)
+		logInfo("Serving health at %s/health", addr)
+	}
+
+	logInfo("Serving metrics at %s/metrics", addr)
+
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		logError("Unable to serve metrics: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package resharding
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	metricSlaves.Inc()
+	defer metricSlaves.Dec()
+
+	recorder := httptest.NewRecorder()
+	metricsHandler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+
+	for _, m := range metrics {
+		if !strings.Contains(body, "# TYPE "+m.name+" "+m.kind+"\n") {
+			t.Errorf("Metric %s is missing in output: %s", m.name, body)
+		}
+	}
+
+	if !strings.Contains(body, "\nredis_resharding_connected_slaves 1\n") {
+		t.Errorf("Gauge value is missing in output: %s", body)
+	}
+}
+
+func TestCountingKeyMatches(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+	p.Matcher = SlotMatcher{{KeyHashSlot("foo"), KeyHashSlot("foo")}}
+
+	kept, skipped := metricKeysKept.Value(), metricKeysSkipped.Value()
+
+	if !p.countingKeyMatches("foo") || p.countingKeyMatches("bar") {
+		t.Errorf("Key predicate result doesn't match")
+	}
+
+	if metricKeysKept.Value() != kept+1 || metricKeysSkipped.Value() != skipped+1 {
+		t.Errorf("Key counters should have been incremented")
+	}
+}
+
+func TestQueueLength(t *testing.T) {
+	first, second := make(chan []byte, 10), make(chan []byte, 10)
+
+	slaveQueues.add(first)
+	slaveQueues.add(second)
+
+	first <- []byte("PING\r\n")
+	second <- []byte("PING\r\n")
+	second <- []byte("PING\r\n")
+
+	if metricSlaveQueued.Value() != 3 {
+		t.Errorf("Queue length doesn't match: %d != 3", metricSlaveQueued.Value())
+	}
+
+	slaveQueues.remove(second)
+
+	if metricSlaveQueued.Value() != 1 {
+		t.Errorf("Queue length doesn't match: %d != 1", metricSlaveQueued.Value())
+	}
+
+	slaveQueues.remove(first)
+
+	if metricSlaveQueued.Value() != 0 {
+		t.Errorf("Queue length doesn't match: %d != 0", metricSlaveQueued.Value())
+	}
+}
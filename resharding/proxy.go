@@ -0,0 +1,1370 @@
+// Package resharding implements Redis replication proxy which filters keys of replication stream,
+// both in initial data (RDB) and in incremental command stream.
+//
+// Proxy pretends to be master for Redis slave (or any other Sink), it connects to real master,
+// requests replication and passes through only keys accepted by Matcher.
+package resharding
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// default size of read & write buffers of master and slave connections
+	bufSize = 16384
+	// default capacity of queues between master and slave
+	channelBuffer = 100
+)
+
+// Proxy holds configuration of resharding proxy, it should be created with NewProxy,
+// options could be changed before proxy is started
+type Proxy struct {
+	// Master address, network is "tcp" or "unix"
+	MasterNetwork string
+	MasterAddr    string
+	MasterAuth    string
+	// ACL user name, requires MasterAuth
+	MasterUser string
+	MasterTLS  *tls.Config
+	// Database to SELECT on master before SYNC, -1 if not set
+	MasterDB int
+	// Master connections are dialed through SOCKS5 or HTTP CONNECT proxy if set, see ParseConnectVia
+	ConnectVia *url.URL
+
+	// Candidate master addresses (network is MasterNetwork), tried in order until one accepts connection
+	// and replication request, MasterAddr is used if empty
+	Masters []string
+	// Try Masters in random order and randomize reconnect delay by up to half of it
+	MasterJitter bool
+
+	// Master is replica of another Redis (chained replication): replication request refused by replica
+	// until it is in sync with its own master is retried like connection failure instead of being passed to slave
+	FromReplica bool
+
+	// Reconnect attempts to master, interval is doubled on every attempt
+	MasterRetryMax      int
+	MasterRetryInterval time.Duration
+	// Connecting to master fails if connection isn't established within timeout, 0 means OS default
+	MasterConnectTimeout time.Duration
+	// Connection to master fails if read or write doesn't finish within timeout, 0 disables timeout
+	MasterReadTimeout  time.Duration
+	MasterWriteTimeout time.Duration
+	// Command argument or bulk value from master or slave longer than MaxArgumentLength bytes fails
+	// replication instead of allocating huge buffer
+	MaxArgumentLength int64
+
+	// Matcher decides which keys are kept, both in RDB and in command stream
+	Matcher KeyMatcher
+	// Rewriter renames kept keys, nil disables renaming
+	Rewriter *KeyRewriter
+	// Databases to keep, nil means all databases
+	Databases []IntRange
+	// Keys from database N of master land in database DBMap[N] of slave, both in RDB and in command stream,
+	// unmapped databases are passed unchanged; Databases refer to master numbers
+	DBMap map[int]int
+	// Only hash fields, set & sorted set members matching FieldPattern are kept in RDB
+	FieldPattern *regexp.Regexp
+	// Verify CRC64 checksum of RDB received from master
+	VerifyRDB bool
+	// Reject RDB produced by Redis version newer than supported one instead of logging warning
+	StrictRDB bool
+	// Entries of RDB bigger than MaxValueSize are skipped (or streamed if StreamOversized is set), 0 means unlimited
+	MaxValueSize    int
+	StreamOversized bool
+	// With MinValueSize or MinElements only big RDB entries are kept: entry should take at least MinValueSize bytes
+	// in RDB or have at least MinElements elements (either is enough if both are set), 0 disables threshold.
+	// Thresholds don't apply to command stream, so Serve accepts them only with Once and ReplicateToSink refuses them
+	MinValueSize int
+	MinElements  int
+	// Pass RDB and command stream through unchanged, without any filtering, rewriting or database mapping
+	NoFilter bool
+	// With NoRDB replication is requested with PSYNC ReplID ReplOffset (instead of slave's own request), so that
+	// only commands from that offset are forwarded to target which already has the data; full resync is refused
+	NoRDB      bool
+	ReplID     string
+	ReplOffset int64
+	// RDB bigger than MaxRDBSize bytes is refused before filtering, 0 means unlimited; size of diskless
+	// transfer isn't known in advance, so it isn't checked
+	MaxRDBSize int64
+	// RDBDone is called once RDB has been filtered and written to slave (or sink, or file by SaveRDB)
+	RDBDone func(stats RDBStats)
+	// Interval of RDB transfer progress logging, 0 disables progress
+	ProgressInterval time.Duration
+	// LogValues enables logging of whole commands in debug log; by default only command names & keys are logged,
+	// other arguments (values, which could contain sensitive data) are redacted
+	LogValues bool
+	// Size of read buffers of master & slave connections and of write buffer of slave connection
+	BufferSize int
+	// Capacity (in messages) of queue of commands from master to slave and of queue from slave to master;
+	// RDB isn't queued, it is written directly through BufferSize write buffer
+	SlaveQueueSize  int
+	MasterQueueSize int
+	// Directory for temporary file written by SaveRDB, default is directory of output file
+	OutputTmpDir string
+	// Compression of file written by SaveRDB: empty for none or "gzip"
+	OutputCompress string
+	// RESTORE commands written by RestoreCommands overwrite existing keys
+	ReplaceExisting bool
+	// Copy of everything sent to slave (RDB and commands) is written to TeeFile, empty disables copying
+	TeeFile string
+
+	// Limit of transfer rate to slave in bytes per second, 0 means unlimited
+	RateLimit int64
+	// Once command stream has started, REPLCONF ACK with offset of master stream is sent to master on slave's
+	// behalf if slave hasn't sent its own ACK within IdlePing, so that master doesn't drop quiet replica;
+	// 0 disables pings
+	IdlePing time.Duration
+	// Slave connection is closed if nothing is received from slave within timeout, 0 disables timeout
+	SlaveIdleTimeout time.Duration
+	// Maximum number of concurrent slave connections, 0 means unlimited
+	MaxSlaves int
+	// Slave connections are accepted only from these networks, nil allows all
+	AllowedNets []*net.IPNet
+	// Additional slave commands forwarded to master (case-insensitive), other unknown commands are rejected
+	SlaveAllow []string
+	// Time to wait for slave connections to finish on shutdown
+	ShutdownTimeout time.Duration
+	// Proxy is closed once first slave reaches command stream (or its connection is closed)
+	Once bool
+
+	// Registry of active slave connections, used to limit number of slaves and for graceful shutdown
+	sessions     map[net.Conn]struct{}
+	sessionsLock sync.Mutex
+	sessionsWg   sync.WaitGroup
+
+	// tee file is used by single slave session at a time
+	teeLock sync.Mutex
+	teeBusy bool
+
+	listeners []net.Listener
+	// number of master connections which are up
+	masters   int32
+	closed    chan struct{}
+	closeOnce sync.Once
+	// address of master last connected to
+	currentMaster atomic.Value
+	// slave connections are shut down only once, even if several listeners are served
+	shutdownOnce sync.Once
+}
+
+// NewProxy creates proxy for master at given address with default options, all keys are kept
+func NewProxy(masterNetwork, masterAddr string) *Proxy {
+	return &Proxy{
+		MasterNetwork:        masterNetwork,
+		MasterAddr:           masterAddr,
+		MasterDB:             -1,
+		MasterRetryMax:       5,
+		MasterRetryInterval:  time.Second,
+		MasterConnectTimeout: 10 * time.Second,
+		MasterReadTimeout:    time.Minute,
+		MasterWriteTimeout:   time.Minute,
+		MaxArgumentLength:    DefaultMaxArgumentLength,
+		Matcher:              AllMatcher{},
+		ProgressInterval:     10 * time.Second,
+		ShutdownTimeout:      5 * time.Second,
+		BufferSize:           bufSize,
+		SlaveQueueSize:       channelBuffer,
+		MasterQueueSize:      channelBuffer,
+		sessions:             make(map[net.Conn]struct{}),
+		closed:               make(chan struct{}),
+	}
+}
+
+// Serve accepts slave connections on listener until proxy is closed, then waits up to
+// ShutdownTimeout for slave connections to finish
+//
+// Serve could be called concurrently for several listeners, all of them share the same slaves
+// limit and are closed together
+func (p *Proxy) Serve(ln net.Listener) error {
+	if p.sizeThresholds() && !p.Once {
+		return ErrSizeThresholds
+	}
+
+	p.sessionsLock.Lock()
+	select {
+	case <-p.closed:
+		p.sessionsLock.Unlock()
+		ln.Close()
+		return nil
+	default:
+	}
+	p.listeners = append(p.listeners, ln)
+	p.sessionsLock.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-p.closed:
+				// other Serve calls wait here until shutdown is finished
+				p.shutdownOnce.Do(func() {
+					p.shutdownSessions(p.ShutdownTimeout)
+				})
+				return nil
+			default:
+			}
+
+			logError("Unable to accept: %v", err)
+			continue
+		}
+
+		if !p.sourceAllowed(conn.RemoteAddr()) {
+			logWarn("Rejecting slave connection from %s, address is not allowed", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+
+		if !p.sessionStarted(conn) {
+			logWarn("Rejecting slave connection from %s, maximum number of slaves (%d) reached", conn.RemoteAddr().String(), p.MaxSlaves)
+			conn.Write(encodeRedisError("ERR max number of slaves reached"))
+			conn.Close()
+			continue
+		}
+
+		go p.slaveReader(conn)
+	}
+}
+
+// Close stops accepting slave connections, Serve returns once slave connections are finished
+func (p *Proxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+
+	p.sessionsLock.Lock()
+	defer p.sessionsLock.Unlock()
+
+	for _, ln := range p.listeners {
+		ln.Close()
+	}
+
+	return nil
+}
+
+// Addrs returns addresses of listeners being served, so that actual port could be found out when
+// listening on port 0
+func (p *Proxy) Addrs() []net.Addr {
+	p.sessionsLock.Lock()
+	defer p.sessionsLock.Unlock()
+
+	addrs := make([]net.Addr, 0, len(p.listeners))
+	for _, ln := range p.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+
+	return addrs
+}
+
+// Close proxy in Once mode, first slave is done
+func (p *Proxy) finishOnce(reason string) {
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+
+	logInfo("%s, shutting down", reason)
+	p.Close()
+}
+
+// Send AUTH to master and check reply
+func masterAuthenticate(conn net.Conn, reader *bufio.Reader, user, password string) error {
+	args := []string{"AUTH", password}
+	if user != "" {
+		args = []string{"AUTH", user, password}
+	}
+
+	_, err := conn.Write(encodeRedisCommand(args...))
+	if err != nil {
+		return fmt.Errorf("Failed to send AUTH: %v", err)
+	}
+
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Failed to read AUTH reply: %v", err)
+	}
+
+	if strings.TrimSpace(reply) != "+OK" {
+		return fmt.Errorf("Authentication failed: %s", strings.TrimSpace(reply))
+	}
+
+	return nil
+}
+
+// Send SELECT to master and check reply
+func masterSelect(conn net.Conn, reader *bufio.Reader, db int) error {
+	_, err := conn.Write(encodeRedisCommand("SELECT", strconv.Itoa(db)))
+	if err != nil {
+		return fmt.Errorf("Failed to send SELECT: %v", err)
+	}
+
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Failed to read SELECT reply: %v", err)
+	}
+
+	if strings.TrimSpace(reply) != "+OK" {
+		return fmt.Errorf("SELECT %d failed: %s", db, strings.TrimSpace(reply))
+	}
+
+	return nil
+}
+
+// Goroutine that handles writing commands to master, stops when ctx is done closing master connection
+//
+// With IdlePing it also acknowledges master offset while slave is quiet.
+func (p *Proxy) masterWriter(ctx context.Context, conn net.Conn, masterchannel <-chan []byte, offset *replicationOffset) {
+	defer conn.Close()
+
+	var ticks <-chan time.Time
+	if p.IdlePing > 0 {
+		ticker := time.NewTicker(p.IdlePing)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case data, ok := <-masterchannel:
+			if !ok {
+				return
+			}
+
+			_, err := conn.Write(data)
+			if err != nil {
+				logError("Failed to write data to master: %v", err)
+				return
+			}
+		case <-ticks:
+			if !offset.idle(p.IdlePing) {
+				continue
+			}
+
+			master, _ := offset.get()
+			logDebug("Slave is idle, sending ACK of offset %d to master", master)
+
+			_, err := conn.Write(encodeRedisCommand("REPLCONF", "ACK", strconv.FormatInt(master, 10)))
+			if err != nil {
+				logError("Failed to write data to master: %v", err)
+				return
+			}
+			offset.ack()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Delay before reconnecting to master, doubles with every attempt
+func (p *Proxy) retryDelay(attempt int) time.Duration {
+	const maxRetryDelay = time.Minute
+
+	delay := p.MasterRetryInterval
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	if p.MasterJitter && delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	}
+
+	return delay
+}
+
+// Last replication request (SYNC/PSYNC) sent by slave, replayed to master on reconnect
+type syncRequest struct {
+	sync.Mutex
+	raw []byte
+}
+
+func (request *syncRequest) set(raw []byte) {
+	request.Lock()
+	defer request.Unlock()
+
+	request.raw = raw
+}
+
+func (request *syncRequest) get() []byte {
+	request.Lock()
+	defer request.Unlock()
+
+	return request.raw
+}
+
+// ErrSizeThresholds is returned when MinValueSize or MinElements are set for replication which goes on after RDB:
+// commands for keys dropped from RDB as too small would still be passed to slave
+var ErrSizeThresholds = errors.New("Size thresholds apply to RDB only and can't be used with command stream")
+
+// Check whether MinValueSize or MinElements is set
+func (p *Proxy) sizeThresholds() bool {
+	return p.MinValueSize > 0 || p.MinElements > 0
+}
+
+// Check whether RDB entry reaches MinValueSize or MinElements, smaller entries are dropped regardless of key
+func (p *Proxy) bigEnough(entry RDBEntry) bool {
+	if !p.sizeThresholds() {
+		return true
+	}
+
+	return p.MinValueSize > 0 && entry.Size >= p.MinValueSize || p.MinElements > 0 && entry.Length >= p.MinElements
+}
+
+// Check whether keys from database should be passed through to slave
+func (p *Proxy) dbSelected(db int) bool {
+	return p.Databases == nil || RangesContain(p.Databases, db)
+}
+
+// RDBStats describes RDB which has been filtered
+type RDBStats struct {
+	KeysKept    int64
+	KeysSkipped int64
+	// Bytes read from master and written to output (without padding)
+	BytesIn  int64
+	BytesOut int64
+	Duration time.Duration
+}
+
+// Filter RDB with configured key matcher and rewriter, size is original size of RDB (zero if unknown),
+// output is padded up to original size if requested, eofMark is set for diskless transfer,
+// source checksum is verified if VerifyRDB is set or verify is requested
+//
+// Returns statistics of filtering and database selected in replication stream right after RDB
+// (-1 if RDB doesn't tell)
+func (p *Proxy) filterRDB(reader *bufio.Reader, output io.Writer, size int64, padding bool, eofMark string, verify bool) (RDBStats, int, error) {
+	length := int64(0)
+	if padding {
+		length = size
+	}
+
+	filter := newRDBFilter(reader, output, p.countingEntryMatches, length)
+	filter.verify = p.VerifyRDB || verify
+	filter.strict = p.StrictRDB
+	filter.dbFilter = p.dbSelected
+	filter.dbMap = p.DBMap
+	filter.eofMark = eofMark
+	filter.maxValueSize = p.MaxValueSize
+	filter.streamOversized = p.StreamOversized
+	if p.FieldPattern != nil {
+		filter.memberFilter = p.FieldPattern.MatchString
+	}
+	if p.Rewriter != nil {
+		filter.rename = p.Rewriter.Rewrite
+	}
+
+	var stats RDBStats
+	filter.entryDone = func(entry RDBEntry, kept bool) {
+		if kept {
+			stats.KeysKept++
+		} else {
+			stats.KeysSkipped++
+		}
+	}
+
+	start := time.Now()
+	if p.ProgressInterval > 0 {
+		filter.progressInterval = p.ProgressInterval
+		filter.progress = func(offset, keys int64) {
+			if size > 0 {
+				logInfo("RDB progress: %d/%d bytes (%.1f%%), %d keys", offset, size, float64(offset)*100/float64(size), keys)
+			} else {
+				logInfo("RDB progress: %d bytes, %d keys", offset, keys)
+			}
+		}
+	}
+
+	err := filter.run()
+
+	stats.BytesIn = filter.offset
+	stats.BytesOut = filter.length
+	stats.Duration = time.Since(start)
+
+	if err == nil {
+		logInfo("RDB filtering finished: %d keys kept, %d skipped, %d bytes in, %d bytes out in %v (%.1f MB/s)",
+			stats.KeysKept, stats.KeysSkipped, stats.BytesIn, stats.BytesOut, stats.Duration,
+			float64(stats.BytesIn)/stats.Duration.Seconds()/(1<<20))
+	}
+
+	return stats, filter.streamDB, err
+}
+
+// Report finished RDB to RDBDone callback
+func (p *Proxy) rdbDone(stats RDBStats) {
+	if p.RDBDone != nil {
+		p.RDBDone(stats)
+	}
+}
+
+// Copy RDB from reader to output unchanged, size is size from bulk header, eofMark is set instead for
+// diskless transfer and is copied too; returns number of bytes read from master
+func copyRDB(reader *bufio.Reader, output io.Writer, size int64, eofMark string) (int64, error) {
+	if eofMark == "" {
+		return io.CopyN(output, reader, size)
+	}
+
+	// mark could span buffered chunks, so tail of previous chunk (shorter than mark) is kept for matching
+	mark := []byte(eofMark)
+	tail := []byte{}
+	read := int64(0)
+
+	for {
+		_, err := reader.Peek(1)
+		if err != nil {
+			return read, err
+		}
+		data, _ := reader.Peek(reader.Buffered())
+
+		window := append(tail, data...)
+		found := bytes.Index(window, mark)
+		if found != -1 {
+			data = data[:found+len(mark)-len(tail)]
+		}
+
+		_, err = output.Write(data)
+		if err != nil {
+			return read, err
+		}
+		reader.Discard(len(data))
+		read += int64(len(data))
+
+		if found != -1 {
+			return read, nil
+		}
+
+		if len(window) >= len(mark) {
+			window = window[len(window)-len(mark)+1:]
+		}
+		tail = append([]byte{}, window...)
+	}
+}
+
+// Check size announced in RDB bulk header against MaxRDBSize
+func (p *Proxy) checkRDBSize(size int64) error {
+	if p.MaxRDBSize > 0 && size > p.MaxRDBSize {
+		return fmt.Errorf("RDB size %d exceeds maximum of %d bytes", size, p.MaxRDBSize)
+	}
+	return nil
+}
+
+// Connect to master and authenticate, connecting is aborted once ctx is done
+func (p *Proxy) dialMaster(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	conn, reader, _, err := p.dialMasterFrom(ctx, 0)
+	return conn, reader, err
+}
+
+// Connect to master at address and authenticate
+func (p *Proxy) dialMasterAddr(ctx context.Context, addr string) (net.Conn, *bufio.Reader, error) {
+	conn, err := p.dialMasterConn(ctx, addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to connect to master: %v", err)
+	}
+
+	if p.MasterReadTimeout > 0 || p.MasterWriteTimeout > 0 {
+		conn = &deadlineConn{Conn: conn, readTimeout: p.MasterReadTimeout, writeTimeout: p.MasterWriteTimeout}
+	}
+
+	if p.MasterTLS != nil {
+		config := p.MasterTLS
+		if len(p.Masters) > 0 {
+			// certificate of every candidate is verified against its own host name
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, config)
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("TLS handshake with master failed: %v", err)
+		}
+		conn = tlsConn
+	}
+
+	reader := bufio.NewReaderSize(conn, p.BufferSize)
+
+	if p.MasterAuth != "" {
+		err = masterAuthenticate(conn, reader, p.MasterUser, p.MasterAuth)
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("Unable to authenticate with master: %v", err)
+		}
+	}
+
+	if p.MasterDB >= 0 {
+		err = masterSelect(conn, reader, p.MasterDB)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	return conn, reader, nil
+}
+
+// replicationOffset tracks offsets of replication stream read from master and forwarded to slave,
+// offsets are shared between master session and slave reader
+//
+// Database selected in the stream is tracked along, so that it survives reconnects to master
+// (partial resync continues in the same database); it is used by master sessions only.
+type replicationOffset struct {
+	master    int64
+	forwarded int64
+	db        int
+	// time of last ACK sent to master (UnixNano), 0 if none yet
+	acked int64
+	// set while master session is in command stream
+	streaming int32
+}
+
+// Start offsets from base offset of master replication stream
+func (offset *replicationOffset) reset(base int64) {
+	atomic.StoreInt64(&offset.master, base)
+	atomic.StoreInt64(&offset.forwarded, base)
+	metricMasterOffset.Set(base)
+	metricForwardedOffset.Set(base)
+}
+
+// Account command read from master, forwarded or not
+func (offset *replicationOffset) read(n int) {
+	metricMasterOffset.Set(atomic.AddInt64(&offset.master, int64(n)))
+}
+
+// Account command forwarded to slave
+func (offset *replicationOffset) forward(n int) {
+	metricForwardedOffset.Set(atomic.AddInt64(&offset.forwarded, int64(n)))
+}
+
+func (offset *replicationOffset) get() (master, forwarded int64) {
+	return atomic.LoadInt64(&offset.master), atomic.LoadInt64(&offset.forwarded)
+}
+
+// Record ACK sent to master by slave or on its behalf
+func (offset *replicationOffset) ack() {
+	atomic.StoreInt64(&offset.acked, time.Now().UnixNano())
+}
+
+// Mark whether master session is in command stream
+func (offset *replicationOffset) setStreaming(streaming bool) {
+	value := int32(0)
+	if streaming {
+		value = 1
+	}
+	atomic.StoreInt32(&offset.streaming, value)
+}
+
+// Check whether command stream is going on, but no ACK has been sent to master within interval
+func (offset *replicationOffset) idle(interval time.Duration) bool {
+	if atomic.LoadInt32(&offset.streaming) == 0 {
+		return false
+	}
+
+	return time.Since(time.Unix(0, atomic.LoadInt64(&offset.acked))) >= interval
+}
+
+// Check whether error reply of replica to SYNC/PSYNC is temporary: replica isn't connected to its master
+// (or is in the middle of sync with it) or is loading RDB
+func replicaNotReady(reply string) bool {
+	for _, prefix := range []string{"-NOMASTERLINK", "-LOADING", "-MASTERDOWN"} {
+		if strings.HasPrefix(reply, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// masterRejectedError is returned when master replies with error to replication request,
+// there is no point in reconnecting in that case
+type masterRejectedError struct {
+	reply string
+}
+
+func (e *masterRejectedError) Error() string {
+	return fmt.Sprintf("Master rejected replication: %s", e.reply)
+}
+
+// ErrFullResync is returned when master forces full resync, while RDB is disabled with NoRDB
+var ErrFullResync = errors.New("Master requires full resync, but RDB is disabled")
+
+// PSYNC request sent to master instead of slave's request with NoRDB, offset is reset accordingly
+func (p *Proxy) partialSyncRequest(offset *replicationOffset) []byte {
+	logInfo("Requesting partial resync, replication id %s, offset %d", p.ReplID, p.ReplOffset)
+	offset.reset(p.ReplOffset - 1)
+
+	return encodeRedisCommand("PSYNC", p.ReplID, strconv.FormatInt(p.ReplOffset, 10))
+}
+
+// Connect to master, request replication and filter it, reconnecting with backoff
+//
+// Reconnect is transparent to the slave only while master hasn't started replication (no FULLRESYNC or RDB
+// has been sent to slave yet), slave's replication request is replayed to the new master connection. Once
+// replication has started, new master connection would produce another RDB which can't be interleaved with
+// the stream slave has already received, so slave connection is closed forcing slave to start full resync.
+//
+// Returns once ctx of slave session is done or master connection is given up, error is the last error of master
+// connection.
+func (p *Proxy) masterConnection(ctx context.Context, slaveConn io.Closer, output *slaveOutput, slavechannel chan<- []byte, masterchannel <-chan []byte, request *syncRequest, offset *replicationOffset) error {
+	// candidate master to start with, the one after candidate which refused replication
+	first := 0
+
+	for attempt := 0; ; attempt++ {
+		started, err := p.masterSession(ctx, output, slavechannel, masterchannel, request, offset, attempt > 0, first)
+
+		if ctx.Err() != nil {
+			logInfo("Slave session is finished, master connection is closed")
+			return err
+		}
+
+		logError("Master connection failed: %v", err)
+
+		first = 0
+		if refused, ok := err.(*candidateRefusedError); ok {
+			first = refused.candidate + 1
+		}
+
+		if _, ok := err.(*masterRejectedError); ok {
+			// make sure error reply reaches slave before closing connection
+			if output.acquire(ctx, slavechannel) == nil {
+				output.release()
+			}
+			slaveConn.Close()
+			return err
+		}
+
+		if err == ErrFullResync {
+			slaveConn.Close()
+			return err
+		}
+
+		if started {
+			logWarn("Replication has already started, closing slave connection to force full resync")
+			slaveConn.Close()
+			return err
+		}
+
+		if attempt >= p.MasterRetryMax {
+			logError("Giving up on master after %d attempt(s), closing slave connection", attempt+1)
+			slaveConn.Close()
+			return err
+		}
+
+		delay := p.retryDelay(attempt)
+		logWarn("Reconnecting to master in %v", delay)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// masterState tracks where master connection is in replication protocol
+type masterState int
+
+const (
+	// replies to slave's handshake (REPLCONF, SYNC/PSYNC) are passed through
+	masterHandshake masterState = iota
+	// FULLRESYNC has been received, RDB bulk header is expected
+	masterAwaitingRDB
+	// RDB has been transferred or partial resync accepted, commands are filtered
+	masterStreaming
+)
+
+// Check whether bulk from master starts RDB transfer rather than being bulk reply to command forwarded
+// from slave (e.g. allowed with SlaveAllow): RDB is expected after FULLRESYNC, reply to plain SYNC is
+// recognized by RDB signature; once RDB has been transferred, bulks are always replies
+func rdbTransfer(command *redisCommand, reader *bufio.Reader, state masterState, syncRequested bool) bool {
+	if command.eofMark != "" {
+		return true
+	}
+	if command.bulkSize <= 0 || state == masterStreaming {
+		return false
+	}
+	if state == masterAwaitingRDB {
+		return true
+	}
+	if !syncRequested || command.bulkSize < int64(len(rdbSignature)) {
+		return false
+	}
+
+	signature, err := reader.Peek(len(rdbSignature))
+	return err == nil && bytes.Equal(signature, rdbSignature)
+}
+
+// Handle line received from master after FULLRESYNC, but before RDB bulk header; returns whether
+// it should be forwarded to slave
+//
+// Master sends newlines as keepalives while RDB is being generated, those are forwarded, so that slave
+// doesn't time out. Some masters & proxies send extra status lines (e.g. repeated +FULLRESYNC or +CONTINUE),
+// slave which has already got PSYNC reply would take them for malformed RDB header, so they are consumed.
+// Error reply is forwarded, anything else means stream is out of sync.
+func rdbPreamble(command *redisCommand) (bool, error) {
+	switch {
+	case command.command == nil && command.reply == "" && (string(command.raw) == "\n" || string(command.raw) == "\r\n"):
+		logDebug("Got newline keepalive from master while waiting for RDB")
+		return true, nil
+	case strings.HasPrefix(command.reply, "-"):
+		logError("Got error from master while waiting for RDB: %s", command.reply[1:])
+		return true, nil
+	case command.reply != "" && command.raw[0] == '+':
+		logInfo("Skipping status reply from master while waiting for RDB: %s", command.reply)
+		return false, nil
+	case command.bulkSize < 0:
+		// null bulk reply ($-1) to command forwarded from slave, it isn't RDB
+		logDebug("Got null bulk reply from master while waiting for RDB")
+		return true, nil
+	}
+
+	return false, fmt.Errorf("Unexpected data from master while waiting for RDB: %q", command.raw)
+}
+
+// Single connection to master, returns whether replication has started
+//
+// Replication offset is advanced by commands of replication stream (RDB is not counted, same as in Redis)
+// Candidate masters are tried starting with first one.
+func (p *Proxy) masterSession(ctx context.Context, output *slaveOutput, slavechannel chan<- []byte, masterchannel <-chan []byte, request *syncRequest, offset *replicationOffset, reconnect bool, first int) (started bool, err error) {
+	conn, reader, candidate, err := p.dialMasterFrom(ctx, first)
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	atomic.AddInt32(&p.masters, 1)
+	defer atomic.AddInt32(&p.masters, -1)
+
+	if raw := request.get(); reconnect && raw != nil {
+		logInfo("Replaying replication request to master")
+
+		_, err = conn.Write(raw)
+		if err != nil {
+			return false, fmt.Errorf("Failed to write data to master: %v", err)
+		}
+	}
+
+	// masterWriter closes master connection once either this session or whole slave session is finished,
+	// so blocked read from master fails right away
+	sessionCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	go func() {
+		defer recoverSession(output)
+		p.masterWriter(sessionCtx, conn, masterchannel, offset)
+	}()
+
+	state := masterHandshake
+	offset.setStreaming(false)
+
+	for {
+		command, err := readRedisCommand(reader, p.MaxArgumentLength)
+		if err != nil {
+			return started, fmt.Errorf("Error while reading from master: %v", err)
+		}
+
+		metricMasterCommands.Inc()
+
+		if state == masterAwaitingRDB && command.bulkSize <= 0 && command.eofMark == "" {
+			forward, err := rdbPreamble(command)
+			if err != nil {
+				return started, err
+			}
+			if forward {
+				err = output.send(ctx, slavechannel, command.raw, nil)
+				if err != nil {
+					return started, err
+				}
+			}
+			if strings.HasPrefix(command.reply, "-") {
+				return started, &masterRejectedError{reply: command.reply[1:]}
+			}
+		} else if strings.HasPrefix(command.reply, "FULLRESYNC") {
+			// PSYNC reply, replication id & offset are passed to slave unchanged
+			replID, base, err := parseFullResync(command.reply)
+			if err != nil {
+				return started, fmt.Errorf("Error while reading from master: %v", err)
+			}
+			if p.NoRDB {
+				// reconnecting would end up in full resync again
+				return true, ErrFullResync
+			}
+			logInfo("Full resync from master, replication id %s, offset %d", replID, base)
+			started = true
+			state = masterAwaitingRDB
+			offset.reset(base)
+
+			err = output.send(ctx, slavechannel, command.raw, nil)
+			if err != nil {
+				return started, err
+			}
+		} else if strings.HasPrefix(command.reply, "CONTINUE") {
+			logInfo("Partial resync accepted by master")
+			started = true
+			state = masterStreaming
+			offset.setStreaming(true)
+
+			err = output.send(ctx, slavechannel, command.raw, nil)
+			if err != nil {
+				return started, err
+			}
+		} else if rdbTransfer(command, reader, state, request.get() != nil) {
+			// RDB Transfer
+
+			if command.eofMark != "" {
+				logInfo("Diskless RDB transfer")
+			} else {
+				logInfo("RDB size: %d", command.bulkSize)
+			}
+			// retrying would produce the same RDB, so it's treated as started replication, which isn't retried
+			started = true
+
+			err = p.checkRDBSize(command.bulkSize)
+			if err != nil {
+				return started, err
+			}
+
+			err = output.acquire(ctx, slavechannel)
+			if err != nil {
+				return started, err
+			}
+
+			var (
+				stats    RDBStats
+				streamDB int
+			)
+			_, err = output.Write(command.raw)
+			if err == nil && p.NoFilter {
+				start := time.Now()
+				stats.BytesIn, err = copyRDB(reader, output, command.bulkSize, command.eofMark)
+				stats.BytesOut, stats.Duration = stats.BytesIn, time.Since(start)
+				streamDB = -1
+			} else if err == nil {
+				stats, streamDB, err = p.filterRDB(reader, output, command.bulkSize, true, command.eofMark, false)
+			}
+			releaseErr := output.release()
+			if err != nil {
+				return started, fmt.Errorf("Unable to transfer RDB: %v", err)
+			}
+			if releaseErr != nil {
+				return started, fmt.Errorf("Failed to write data to slave: %v", releaseErr)
+			}
+
+			metricRDBBytes.Add(stats.BytesIn)
+			p.rdbDone(stats)
+
+			// slave loads RDB into its databases and continues command stream in the database
+			// master had selected, which is recorded in RDB (Redis 4.0+); commands before
+			// the first SELECT are filtered according to that database
+			offset.db = 0
+			if streamDB >= 0 {
+				offset.db = streamDB
+				logInfo("Command stream continues in database %d", streamDB)
+			}
+
+			logInfo("RDB transferred, filtering commands...")
+			state = masterStreaming
+			offset.setStreaming(true)
+		} else if !started && strings.HasPrefix(command.reply, "-") && request.get() != nil {
+			if len(p.Masters) > 1 {
+				// another candidate could be the master now
+				return false, &candidateRefusedError{candidate: candidate, reply: command.reply[1:]}
+			}
+			if p.FromReplica && replicaNotReady(command.reply) {
+				// replica refuses replication until it is in sync with its own master, slave waits for reconnect
+				return false, fmt.Errorf("Replica is not ready to serve replication: %s", command.reply[1:])
+			}
+
+			// error reply to SYNC/PSYNC, RDB is never going to come
+			err = output.send(ctx, slavechannel, command.raw, nil)
+			if err != nil {
+				return false, err
+			}
+
+			return false, &masterRejectedError{reply: command.reply[1:]}
+		} else if command.bulkSize > 0 {
+			// bulk reply to command forwarded from slave, it isn't part of replication stream
+			payload := make([]byte, command.bulkSize+2)
+			_, err = io.ReadFull(reader, payload)
+			if err != nil {
+				return started, fmt.Errorf("Error while reading from master: %v", err)
+			}
+
+			err = output.send(ctx, slavechannel, append(command.raw, payload...), nil)
+			if err != nil {
+				return started, err
+			}
+		} else if command.bulkSize < 0 {
+			// null bulk reply ($-1) to command forwarded from slave, it isn't part of replication stream
+			err = output.send(ctx, slavechannel, command.raw, nil)
+			if err != nil {
+				return started, err
+			}
+		} else if command.reply != "" || command.command == nil && command.bulkSize == 0 {
+			// passthrough reply & empty command
+			err = output.send(ctx, slavechannel, command.raw, nil)
+			if err != nil {
+				return started, err
+			}
+		} else if len(command.command) == 1 && command.command[0] == "PING" {
+			logDebug("Got PING from master")
+
+			if started {
+				offset.read(len(command.raw))
+				offset.forward(len(command.raw))
+			}
+
+			err = output.send(ctx, slavechannel, command.raw, nil)
+			if err != nil {
+				return started, err
+			}
+		} else {
+			offset.read(len(command.raw))
+
+			if selected, ok := selectedDB(command); ok {
+				offset.db = selected
+			}
+
+			keep := p.NoFilter || filterCommand(command, p.Matcher.Match)
+			if keep && !p.NoFilter && !p.dbSelected(offset.db) && commandHasKeys(command) {
+				keep = false
+			}
+
+			if !keep {
+				metricFilteredCommands.Inc()
+				if logEnabled(levelDebug) {
+					logDebug("Command %s filtered out", commandLogString(command, p.LogValues))
+				}
+				continue
+			}
+
+			if logEnabled(levelDebug) {
+				logDebug("Command %s kept", commandLogString(command, p.LogValues))
+			}
+
+			metricForwardedCommands.Inc()
+
+			if p.Rewriter != nil && !p.NoFilter {
+				p.Rewriter.RewriteCommand(command)
+			}
+			if p.DBMap != nil && !p.NoFilter {
+				remapSelect(command, p.DBMap)
+			}
+
+			offset.forward(len(command.raw))
+
+			err = output.send(ctx, slavechannel, command.raw, nil)
+			if err != nil {
+				return started, err
+			}
+		}
+
+	}
+}
+
+// slaveOutput is rate limited writer to slave connection (or other sink)
+//
+// It is owned by slaveWriter goroutine, which writes data coming through slavechannel. For RDB transfer
+// slaveWriter hands output over, so that filtered RDB is written directly instead of being copied through
+// slavechannel chunk by chunk.
+type slaveOutput struct {
+	sink     Sink
+	limiter  *rateLimiter
+	acquired chan struct{}
+	released chan struct{}
+	// closed when slaveWriter is finished
+	done chan struct{}
+	// cancels slave session once write to slave fails
+	cancel context.CancelFunc
+	// remote address of slave, for logging
+	slave string
+	// flushes are postponed while more data is queued, so that burst of commands
+	// is written with single syscall; sink which needs every segment delivered
+	// separately should keep it unset
+	batchFlushes bool
+}
+
+// Marker sent through slavechannel to hand output over, nil is reserved for flush
+var handoffMarker = []byte{}
+
+func newSlaveOutput(sink Sink, rateLimit int64, cancel context.CancelFunc) *slaveOutput {
+	output := &slaveOutput{
+		sink:     sink,
+		acquired: make(chan struct{}),
+		released: make(chan struct{}),
+		done:     make(chan struct{}),
+		cancel:   cancel,
+	}
+
+	if rateLimit > 0 {
+		output.limiter = newRateLimiter(rateLimit)
+	}
+
+	return output
+}
+
+func (output *slaveOutput) Write(data []byte) (int, error) {
+	if output.limiter != nil {
+		output.limiter.Wait(len(data))
+	}
+	return output.sink.Write(data)
+}
+
+// Queue data for slaveWriter, fails once slave session is finished instead of blocking
+// on slavechannel nobody reads
+func (output *slaveOutput) send(ctx context.Context, slavechannel chan<- []byte, data ...[]byte) error {
+	for _, d := range data {
+		select {
+		case slavechannel <- d:
+		case <-ctx.Done():
+			return fmt.Errorf("Slave connection is closed")
+		}
+	}
+	return nil
+}
+
+// Take output over from slaveWriter, data queued in slavechannel before is written first
+func (output *slaveOutput) acquire(ctx context.Context, slavechannel chan<- []byte) error {
+	err := output.send(ctx, slavechannel, handoffMarker)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-output.acquired:
+		return nil
+	case <-output.done:
+		return fmt.Errorf("Slave connection is closed")
+	}
+}
+
+// Flush output and return it to slaveWriter
+func (output *slaveOutput) release() error {
+	err := output.sink.Flush()
+	output.released <- struct{}{}
+	return err
+}
+
+// Goroutine that handles writing data back to slave, stops once slavechannel is closed or
+// ctx is done; failed write cancels slave session and closes sink, so that slave and
+// master connections are torn down
+func slaveWriter(ctx context.Context, output *slaveOutput, slavechannel <-chan []byte) {
+	defer close(output.done)
+
+	for {
+		var data []byte
+
+		select {
+		case d, ok := <-slavechannel:
+			if !ok {
+				return
+			}
+			data = d
+		case <-ctx.Done():
+			return
+		}
+
+		var err error
+
+		if data == nil {
+			if output.batchFlushes && len(slavechannel) > 0 {
+				// queued data is followed by its own flush
+				continue
+			}
+			err = output.sink.Flush()
+		} else if len(data) == 0 {
+			// RDB is written directly until output is released
+			output.acquired <- struct{}{}
+			<-output.released
+		} else {
+			_, err = output.Write(data)
+		}
+
+		if err != nil {
+			logError("Failed to write data to slave: %v", err)
+			output.cancel()
+			output.sink.Close()
+			return
+		}
+	}
+}
+
+// Check whether slave command is allowed to be forwarded to master with SlaveAllow
+func (p *Proxy) slaveAllowed(command *redisCommand) bool {
+	if len(command.command) == 0 {
+		return false
+	}
+
+	for _, name := range p.SlaveAllow {
+		if strings.EqualFold(name, command.command[0]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Read commands from slave
+func (p *Proxy) slaveReader(conn net.Conn) {
+	defer p.sessionFinished(conn)
+	defer conn.Close()
+
+	if p.Once {
+		defer p.finishOnce("Slave connection closed")
+	}
+
+	logInfo("Slave connection established from %s", conn.RemoteAddr().String())
+
+	metricSlaves.Inc()
+	defer metricSlaves.Dec()
+
+	var (
+		source io.Reader = conn
+		idle   *idleTimeoutConn
+	)
+	if p.SlaveIdleTimeout > 0 {
+		idle = &idleTimeoutConn{Conn: conn, timeout: p.SlaveIdleTimeout}
+		source = idle
+	}
+
+	reader := bufio.NewReaderSize(source, p.BufferSize)
+
+	// channel for writing to slave, it isn't closed as both slaveReader and masterConnection
+	// write to it, slaveWriter is stopped by cancelling output instead
+	slavechannel := make(chan []byte, p.SlaveQueueSize)
+	slaveQueues.add(slavechannel)
+	defer slaveQueues.remove(slavechannel)
+
+	// channel for writing to master
+	masterchannel := make(chan []byte, p.MasterQueueSize)
+	masterQueues.add(masterchannel)
+	defer masterQueues.remove(masterchannel)
+	defer close(masterchannel)
+
+	// slave session is cancelled when slave connection is finished or write to slave fails,
+	// which stops all goroutines of the session and closes master connection
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	request := &syncRequest{}
+	offset := &replicationOffset{}
+
+	sink, releaseTee := p.teeSink(newConnSink(conn, p.BufferSize))
+	output := newSlaveOutput(sink, p.RateLimit, cancel)
+	output.batchFlushes = true
+	output.slave = conn.RemoteAddr().String()
+
+	// panic in any goroutine of the session closes only this session
+	defer recoverSession(output)
+
+	go func() {
+		defer recoverSession(output)
+		slaveWriter(ctx, output, slavechannel)
+	}()
+	go func() {
+		// tee file is closed once slaveWriter is done with sink
+		<-output.done
+		releaseTee()
+	}()
+	go func() {
+		defer recoverSession(output)
+		p.masterConnection(ctx, conn, output, slavechannel, masterchannel, request, offset)
+	}()
+
+	for {
+		command, err := readRedisCommand(reader, p.MaxArgumentLength)
+		if err != nil {
+			logWarn("Error while reading from slave: %v", err)
+			return
+		}
+
+		if command.reply != "" || command.command == nil && command.bulkSize == 0 {
+			// passthrough reply & empty command
+			masterchannel <- command.raw
+		} else if len(command.command) == 1 && command.command[0] == "PING" {
+			logDebug("Got PING from slave")
+
+			masterchannel <- command.raw
+		} else if p.NoRDB && (len(command.command) == 1 && command.command[0] == "SYNC" || len(command.command) == 3 && command.command[0] == "PSYNC") {
+			if idle != nil {
+				idle.pause()
+			}
+			request.set(p.partialSyncRequest(offset))
+			masterchannel <- request.get()
+		} else if len(command.command) == 1 && command.command[0] == "SYNC" {
+			logInfo("Starting SYNC")
+
+			if idle != nil {
+				idle.pause()
+			}
+			request.set(command.raw)
+			masterchannel <- command.raw
+		} else if len(command.command) == 3 && command.command[0] == "PSYNC" {
+			logInfo("Starting PSYNC, replication id %s, offset %s", command.command[1], command.command[2])
+
+			// on partial resync master continues from the offset requested by slave
+			if requested, err := strconv.ParseInt(command.command[2], 10, 64); err == nil && requested > 0 {
+				offset.reset(requested - 1)
+			}
+
+			if idle != nil {
+				idle.pause()
+			}
+			request.set(command.raw)
+			masterchannel <- command.raw
+		} else if len(command.command) == 3 && command.command[0] == "REPLCONF" && command.command[1] == "ACK" {
+			master, forwarded := offset.get()
+			logInfo("Got ACK from slave, offset %s (forwarded %d, master %d)", command.command[2], forwarded, master)
+
+			// slave sends ACKs once RDB is loaded
+			if idle != nil {
+				idle.resume()
+			}
+			offset.ack()
+
+			masterchannel <- command.raw
+
+			if p.Once {
+				p.finishOnce("Slave reached command stream")
+			}
+		} else if len(command.command) == 3 && strings.EqualFold(command.command[0], "WAIT") {
+			// integer reply of master is passed back to slave
+			logInfo("Got WAIT %s %s from slave", command.command[1], command.command[2])
+
+			masterchannel <- command.raw
+		} else if len(command.command) >= 2 && strings.EqualFold(command.command[0], "REPLCONF") {
+			logInfo("Got REPLCONF %s from slave", strings.Join(command.command[1:], " "))
+
+			masterchannel <- command.raw
+		} else if p.slaveAllowed(command) {
+			logInfo("Got %s from slave", strings.ToUpper(command.command[0]))
+
+			masterchannel <- command.raw
+		} else {
+			// unknown command
+			name := ""
+			if len(command.command) > 0 {
+				name = command.command[0]
+			}
+			err = output.send(ctx, slavechannel, encodeRedisError("ERR unknown command '%s'", name), nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
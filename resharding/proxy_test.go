@@ -0,0 +1,1302 @@
+package resharding
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{10, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, test := range tests {
+		delay := p.retryDelay(test.attempt)
+		if delay != test.expected {
+			t.Errorf("Delay for attempt %d doesn't match: %v != %v", test.attempt, delay, test.expected)
+		}
+	}
+
+	p.MasterJitter = true
+	for _, test := range tests {
+		delay := p.retryDelay(test.attempt)
+		if delay < test.expected || delay > test.expected*3/2 {
+			t.Errorf("Delay with jitter for attempt %d out of bounds: %v", test.attempt, delay)
+		}
+	}
+}
+
+func TestMasterConnectTimeout(t *testing.T) {
+	// TEST-NET-1 address, packets to it are dropped, so connect hangs until timeout
+	p := NewProxy("tcp", "192.0.2.1:6379")
+	p.MasterConnectTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, _, err := p.dialMaster(context.Background())
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Skip("Connected to TEST-NET address, unable to check connect timeout")
+	}
+	if !strings.Contains(err.Error(), "timeout") {
+		t.Skipf("Connect failed without timeout, unable to check connect timeout: %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Connect to master took %v, expected to time out after %v", elapsed, p.MasterConnectTimeout)
+	}
+}
+
+func TestSlaveOutputHandoff(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	slavechannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	slavechannel <- []byte("+FULLRESYNC\r\n")
+
+	err := output.acquire(ctx, slavechannel)
+	if err != nil {
+		t.Fatalf("Unable to acquire output: %v", err)
+	}
+	output.Write([]byte("$3\r\nRDB"))
+	err = output.release()
+	if err != nil {
+		t.Fatalf("Unable to release output: %v", err)
+	}
+
+	slavechannel <- []byte("*1\r\n$4\r\nPING\r\n")
+	slavechannel <- nil
+	close(slavechannel)
+	<-output.done
+	server.Close()
+
+	expected := "+FULLRESYNC\r\n$3\r\nRDB*1\r\n$4\r\nPING\r\n"
+	if data := <-received; data != expected {
+		t.Errorf("Output not equal to expected %#v != %#v", data, expected)
+	}
+}
+
+func TestMasterRejectsSync(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan int, 10)
+	go func() {
+		for i := 1; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- i
+
+			bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte("-ERR replication not allowed\r\n"))
+		}
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+	p.MasterRetryInterval = time.Millisecond
+
+	server, client := net.Pipe()
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	slavechannel := make(chan []byte, channelBuffer)
+	masterchannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	request := &syncRequest{}
+	request.set([]byte("SYNC\r\n"))
+	masterchannel <- []byte("SYNC\r\n")
+
+	p.masterConnection(ctx, server, output, slavechannel, masterchannel, request, &replicationOffset{})
+
+	if data := <-received; data != "-ERR replication not allowed\r\n" {
+		t.Errorf("Error reply should be relayed to slave: %#v", data)
+	}
+
+	if len(accepted) != 1 {
+		t.Errorf("Proxy shouldn't reconnect to master after rejection: %d connections", len(accepted))
+	}
+}
+
+func TestReplicaNotReady(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan int, 10)
+	go func() {
+		for i := 1; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- i
+
+			bufio.NewReader(conn).ReadString('\n')
+			if i == 1 {
+				conn.Write([]byte("-NOMASTERLINK Can't SYNC while not connected with my master\r\n"))
+			} else {
+				conn.Write([]byte("-ERR replication not allowed\r\n"))
+			}
+		}
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+	p.MasterRetryInterval = time.Millisecond
+	p.FromReplica = true
+
+	server, client := net.Pipe()
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	slavechannel := make(chan []byte, channelBuffer)
+	masterchannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	request := &syncRequest{}
+	request.set([]byte("SYNC\r\n"))
+	masterchannel <- []byte("SYNC\r\n")
+
+	p.masterConnection(ctx, server, output, slavechannel, masterchannel, request, &replicationOffset{})
+
+	if data := <-received; data != "-ERR replication not allowed\r\n" {
+		t.Errorf("Only final error reply should be relayed to slave: %#v", data)
+	}
+
+	if len(accepted) != 2 {
+		t.Errorf("Proxy should reconnect to replica which isn't ready: %d connections", len(accepted))
+	}
+}
+
+func TestSlaveWait(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		args, _, err := ParseCommand(bufio.NewReader(conn))
+		if err != nil || len(args) != 3 || args[0] != "WAIT" {
+			conn.Write([]byte("-ERR expected WAIT\r\n"))
+			return
+		}
+		conn.Write([]byte(":1\r\n"))
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+
+	server, client := net.Pipe()
+
+	p.sessionStarted(server)
+	done := make(chan struct{})
+	go func() {
+		p.slaveReader(server)
+		close(done)
+	}()
+
+	client.Write(encodeRedisCommand("WAIT", "1", "100"))
+
+	reply, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil || reply != ":1\r\n" {
+		t.Errorf("Output not equal to expected %#v != %#v (%v)", ":1\r\n", reply, err)
+	}
+
+	client.Close()
+	<-done
+}
+
+func TestMasterSelect(t *testing.T) {
+	tests := []struct {
+		description string
+		reply       string
+		expectedErr string
+	}{
+		{"1: Selected", "+OK\r\n", ""},
+		{"2: Out of range", "-ERR DB index is out of range\r\n", "SELECT 20 failed: -ERR DB index is out of range"},
+	}
+
+	for _, test := range tests {
+		server, client := net.Pipe()
+
+		sent := make(chan []string, 1)
+		go func() {
+			args, _, _ := ParseCommand(bufio.NewReader(server))
+			sent <- args
+			server.Write([]byte(test.reply))
+		}()
+
+		err := masterSelect(client, bufio.NewReader(client), 20)
+		errString := ""
+		if err != nil {
+			errString = err.Error()
+		}
+
+		if errString != test.expectedErr {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", test.expectedErr, errString, test.description)
+		}
+
+		if args := <-sent; len(args) != 2 || args[0] != "SELECT" || args[1] != "20" {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", []string{"SELECT", "20"}, args, test.description)
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestReplicationOffset(t *testing.T) {
+	offset := &replicationOffset{}
+	offset.reset(1000)
+	offset.read(30)
+	offset.forward(20)
+	offset.read(10)
+
+	master, forwarded := offset.get()
+	if master != 1040 || forwarded != 1020 {
+		t.Errorf("Offsets don't match: master %d, forwarded %d", master, forwarded)
+	}
+
+	if metricMasterOffset.Value() != 1040 || metricForwardedOffset.Value() != 1020 {
+		t.Errorf("Offset gauges don't match: master %d, forwarded %d", metricMasterOffset.Value(), metricForwardedOffset.Value())
+	}
+}
+
+func TestIdlePing(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+	p.IdlePing = 10 * time.Millisecond
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	offset := &replicationOffset{}
+	offset.reset(1000)
+	offset.read(40)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.masterWriter(ctx, server, make(chan []byte), offset)
+
+	// nothing is sent before command stream starts
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Errorf("ACK shouldn't be sent before command stream")
+	}
+
+	offset.setStreaming(true)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	command, err := readRedisCommand(bufio.NewReader(client), DefaultMaxArgumentLength)
+	if err != nil {
+		t.Fatalf("Unable to read ACK: %v", err)
+	}
+	if !reflect.DeepEqual(command.command, []string{"REPLCONF", "ACK", "1040"}) {
+		t.Errorf("Output not equal to expected %#v != %#v", command.command, []string{"REPLCONF", "ACK", "1040"})
+	}
+
+	// ACK of slave postpones pings
+	offset.ack()
+	if offset.idle(time.Second) {
+		t.Errorf("Offset shouldn't be idle right after ACK")
+	}
+	if !offset.idle(0) {
+		t.Errorf("Offset should be idle after interval")
+	}
+}
+
+func TestStreamDatabase(t *testing.T) {
+	tests := []struct {
+		description string
+		aux         string
+		expected    bool
+	}{
+		{"1: Database recorded in RDB", "\xfa\x0erepl-stream-db\xc0\x01", true},
+		{"2: Database not recorded, default is 0", "", false},
+	}
+
+	for _, test := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Unable to listen: %v", err)
+		}
+
+		rdb := "REDIS0009" + test.aux + "\xfe\x01\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			bufio.NewReader(conn).ReadString('\n')
+			fmt.Fprintf(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n$%d\r\n%s", len(rdb), rdb)
+			// command before first SELECT belongs to database selected at RDB snapshot
+			conn.Write(encodeRedisCommand("SET", "a", "1"))
+			conn.Write(encodeRedisCommand("SELECT", "0"))
+			conn.Write(encodeRedisCommand("SET", "b", "2"))
+		}()
+
+		p := NewProxy("tcp", ln.Addr().String())
+		p.MasterRetryMax = 0
+		p.Databases = []IntRange{{1, 1}}
+
+		server, client := net.Pipe()
+		received := make(chan string)
+		go func() {
+			data, _ := ioutil.ReadAll(client)
+			received <- string(data)
+		}()
+
+		slavechannel := make(chan []byte, channelBuffer)
+		masterchannel := make(chan []byte, channelBuffer)
+		ctx, cancel := context.WithCancel(context.Background())
+		output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+		go slaveWriter(ctx, output, slavechannel)
+
+		request := &syncRequest{}
+		request.set(encodeRedisCommand("PSYNC", "?", "-1"))
+		masterchannel <- request.get()
+
+		// slave connection is closed below, once everything queued is written
+		p.masterConnection(ctx, ioutil.NopCloser(nil), output, slavechannel, masterchannel, request, &replicationOffset{})
+		close(slavechannel)
+		<-output.done
+		server.Close()
+		cancel()
+		ln.Close()
+
+		data := <-received
+		if kept := strings.Contains(data, string(encodeRedisCommand("SET", "a", "1"))); kept != test.expected {
+			t.Errorf("Command before SELECT kept: %v != %v (test %s)", kept, test.expected, test.description)
+		}
+		if !strings.Contains(data, string(encodeRedisCommand("SELECT", "0"))) {
+			t.Errorf("SELECT should be forwarded (test %s)", test.description)
+		}
+		if strings.Contains(data, string(encodeRedisCommand("SET", "b", "2"))) {
+			t.Errorf("Command in database 0 should be filtered out (test %s)", test.description)
+		}
+	}
+}
+
+func TestRDBPreamble(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		forward     bool
+		shouldFail  bool
+	}{
+		{description: "1: Newline keepalive", input: "\n", forward: true},
+		{description: "2: CRLF keepalive", input: "\r\n", forward: true},
+		{description: "3: Repeated FULLRESYNC", input: "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n", forward: false},
+		{description: "4: CONTINUE", input: "+CONTINUE\r\n", forward: false},
+		{description: "5: Error", input: "-ERR can't fork\r\n", forward: true},
+		{description: "6: Command", input: "*1\r\n$4\r\nPING\r\n", shouldFail: true},
+		{description: "7: Null bulk", input: "$-1\r\n", forward: true},
+		{description: "8: Integer", input: ":1\r\n", shouldFail: true},
+	}
+
+	for _, test := range tests {
+		command, err := readRedisCommand(bufio.NewReader(strings.NewReader(test.input)), DefaultMaxArgumentLength)
+		if err != nil {
+			t.Fatalf("Unable to read command: %v (test %s)", err, test.description)
+		}
+
+		forward, err := rdbPreamble(command)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("Should have failed (test %s)", test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+		} else if forward != test.forward {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", forward, test.forward, test.description)
+		}
+	}
+}
+
+func TestMasterRDBPreamble(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	rdb := "REDIS0006\xfe\x00\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		fmt.Fprintf(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n\n+CONTINUE\r\n\n\n+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n$%d\r\n%s", len(rdb), rdb)
+		conn.Write(encodeRedisCommand("SET", "a_2", "1"))
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+	p.MasterRetryMax = 0
+
+	server, client := net.Pipe()
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	slavechannel := make(chan []byte, channelBuffer)
+	masterchannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	request := &syncRequest{}
+	request.set(encodeRedisCommand("PSYNC", "?", "-1"))
+	masterchannel <- request.get()
+
+	p.masterConnection(ctx, ioutil.NopCloser(nil), output, slavechannel, masterchannel, request, &replicationOffset{})
+	close(slavechannel)
+	<-output.done
+	server.Close()
+
+	data := <-received
+	expected := fmt.Sprintf("+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n\n\n\n$%d\r\n", len(rdb))
+	if !strings.HasPrefix(data, expected) {
+		t.Errorf("Slave should get single FULLRESYNC and keepalives before RDB: %q", data)
+	}
+	if !strings.HasSuffix(data, string(encodeRedisCommand("SET", "a_2", "1"))) {
+		t.Errorf("Command stream should follow RDB: %q", data)
+	}
+}
+
+func TestCopyRDB(t *testing.T) {
+	mark := strings.Repeat("0123456789", 4)
+
+	tests := []struct {
+		description string
+		input       string
+		size        int64
+		eofMark     string
+		expected    string
+		shouldFail  bool
+	}{
+		{
+			description: "1: Sized RDB",
+			input:       "REDIS0006\xffchecksum*1\r\n$4\r\nPING\r\n",
+			size:        18,
+			expected:    "REDIS0006\xffchecksum",
+		},
+		{
+			description: "2: Diskless RDB",
+			input:       "REDIS0006\xffchecksum" + mark + "*1\r\n$4\r\nPING\r\n",
+			eofMark:     mark,
+			expected:    "REDIS0006\xffchecksum" + mark,
+		},
+		{
+			description: "3: Diskless RDB, partial mark inside",
+			input:       "REDIS0006" + mark[:30] + "\xffchecksum" + mark + "+OK\r\n",
+			eofMark:     mark,
+			expected:    "REDIS0006" + mark[:30] + "\xffchecksum" + mark,
+		},
+		{
+			description: "4: Truncated sized RDB",
+			input:       "REDIS0006",
+			size:        18,
+			shouldFail:  true,
+		},
+		{
+			description: "5: Diskless RDB without mark",
+			input:       "REDIS0006\xffchecksum",
+			eofMark:     mark,
+			shouldFail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		// small buffer, so that mark spans several chunks
+		reader := bufio.NewReaderSize(strings.NewReader(test.input), 16)
+		output := &bytes.Buffer{}
+
+		read, err := copyRDB(reader, output, test.size, test.eofMark)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("Should have failed (test %s)", test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+			continue
+		}
+		if output.String() != test.expected || read != int64(len(test.expected)) {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", output.String(), test.expected, test.description)
+		}
+
+		rest, _ := ioutil.ReadAll(reader)
+		if string(rest) != test.input[len(test.expected):] {
+			t.Errorf("Data after RDB should be left in reader: %q (test %s)", rest, test.description)
+		}
+	}
+}
+
+func TestMasterNoFilter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	stream := fmt.Sprintf("+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n$%d\r\n%s", len(RDBFile1), RDBFile1) +
+		string(encodeRedisCommand("SET", "b_2", "1")) + string(encodeRedisCommand("DEL", "a_1", "b_1"))
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, stream)
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+	p.MasterRetryMax = 0
+	p.Matcher = PrefixMatcher{"a_"}
+	p.Rewriter, _ = ParseRewrite("/^b_/c_/")
+	p.NoFilter = true
+
+	server, client := net.Pipe()
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	slavechannel := make(chan []byte, channelBuffer)
+	masterchannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	request := &syncRequest{}
+	request.set(encodeRedisCommand("PSYNC", "?", "-1"))
+	masterchannel <- request.get()
+
+	p.masterConnection(ctx, ioutil.NopCloser(nil), output, slavechannel, masterchannel, request, &replicationOffset{})
+	close(slavechannel)
+	<-output.done
+	server.Close()
+
+	if data := <-received; data != stream {
+		t.Errorf("Output not equal to expected %#v != %#v", data, stream)
+	}
+}
+
+func TestMasterFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	// LF-only lines are passed through as is, commands built by proxy use CRLF
+	stream := "\n" + "PING\n" + "*3\n$3\r\nSET\r\n$3\r\na_1\r\n$1\r\n1\r\n" + "*3\r\n$3\r\nDEL\r\n$3\r\na_2\r\n$3\r\nb_2\r\n"
+	expected := "\n" + "PING\n" + "*3\n$3\r\nSET\r\n$3\r\na_1\r\n$1\r\n1\r\n" + "*2\r\n$3\r\nDEL\r\n$3\r\na_2\r\n"
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		fmt.Fprintf(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\n$%d\n%s%s", len(RDBFile1), RDBFile1, stream)
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+	p.MasterRetryMax = 0
+	p.Matcher = PrefixMatcher{"a_"}
+
+	server, client := net.Pipe()
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	slavechannel := make(chan []byte, channelBuffer)
+	masterchannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	request := &syncRequest{}
+	request.set(encodeRedisCommand("PSYNC", "?", "-1"))
+	masterchannel <- request.get()
+
+	p.masterConnection(ctx, ioutil.NopCloser(nil), output, slavechannel, masterchannel, request, &replicationOffset{})
+	close(slavechannel)
+	<-output.done
+	server.Close()
+
+	data := <-received
+	header := fmt.Sprintf("+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\n$%d\n", len(RDBFile1))
+	if !strings.HasPrefix(data, header) {
+		t.Errorf("Replies should be passed through byte for byte: %q", data)
+	}
+	if !strings.HasSuffix(data, expected) {
+		t.Errorf("Command stream not equal to expected %#v != %#v", data[len(data)-len(expected):], expected)
+	}
+}
+
+// Run single master connection against fake master sending stream after it gets replication request,
+// returns everything sent to slave and replication offsets
+func runMasterConnection(t *testing.T, p *Proxy, stream string, request []byte) (string, *replicationOffset) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, stream)
+	}()
+
+	p.MasterNetwork, p.MasterAddr = "tcp", ln.Addr().String()
+	p.MasterRetryMax = 0
+
+	server, client := net.Pipe()
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	slavechannel := make(chan []byte, channelBuffer)
+	masterchannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(server, bufSize), 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	syncRequest := &syncRequest{}
+	syncRequest.set(request)
+	masterchannel <- request
+
+	offset := &replicationOffset{}
+	p.masterConnection(ctx, ioutil.NopCloser(nil), output, slavechannel, masterchannel, syncRequest, offset)
+	close(slavechannel)
+	<-output.done
+	server.Close()
+
+	return <-received, offset
+}
+
+func TestMasterNullBulk(t *testing.T) {
+	// null bulk reply to command forwarded during handshake is passed through, but not counted in offsets
+	set := string(encodeRedisCommand("SET", "a_1", "1"))
+	rdb := "REDIS0006\xfe\x00\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+	fullResync := fmt.Sprintf("+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n$-1\r\n$%d\r\n", len(rdb))
+
+	tests := []struct {
+		description string
+		stream      string
+		prefix      string
+	}{
+		{"1: Before PSYNC reply", "$-1\r\n+CONTINUE\r\n" + set, "$-1\r\n+CONTINUE\r\n"},
+		{"2: Before RDB", fullResync + rdb + set, fullResync},
+	}
+
+	for _, test := range tests {
+		p := NewProxy("tcp", "")
+		p.Matcher = PrefixMatcher{"a_"}
+
+		data, offset := runMasterConnection(t, p, test.stream, encodeRedisCommand("PSYNC", "8de1787ba490483314a4d30f1c628bc5025eb761", "1"))
+		if !strings.HasPrefix(data, test.prefix) || !strings.HasSuffix(data, set) {
+			t.Errorf("Output doesn't match: %#v (test %s)", data, test.description)
+		}
+
+		if master, forwarded := offset.get(); master != int64(len(set)) || forwarded != int64(len(set)) {
+			t.Errorf("Offsets don't match: %d, %d != %d (test %s)", master, forwarded, len(set), test.description)
+		}
+	}
+}
+
+func TestMasterBulkReply(t *testing.T) {
+	// bulk reply to command allowed for slave (CLIENT GETNAME) isn't taken for RDB
+	set := string(encodeRedisCommand("SET", "a_1", "1"))
+	rdb := "REDIS0006\xfe\x00\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+	reply := "$5\r\nproxy\r\n"
+	rdbHeader := fmt.Sprintf("$%d\r\n", len(rdb))
+	fullResync := "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n"
+
+	tests := []struct {
+		description string
+		request     []byte
+		stream      string
+		prefix      string
+	}{
+		{"1: Before SYNC reply", encodeRedisCommand("SYNC"), reply + rdbHeader + rdb + set, reply + rdbHeader},
+		{"2: Before PSYNC reply", encodeRedisCommand("PSYNC", "?", "-1"), reply + fullResync + rdbHeader + rdb + set, reply + fullResync + rdbHeader},
+		{"3: In command stream", encodeRedisCommand("PSYNC", "?", "-1"), fullResync + rdbHeader + rdb + reply + set, fullResync + rdbHeader},
+	}
+
+	for _, test := range tests {
+		p := NewProxy("tcp", "")
+		p.Matcher = PrefixMatcher{"a_"}
+
+		data, offset := runMasterConnection(t, p, test.stream, test.request)
+		if !strings.HasPrefix(data, test.prefix) || !strings.HasSuffix(data, set) {
+			t.Errorf("Output doesn't match: %#v (test %s)", data, test.description)
+		}
+		if strings.Count(data, reply) != 1 {
+			t.Errorf("Bulk reply should be passed through once: %#v (test %s)", data, test.description)
+		}
+
+		if master, forwarded := offset.get(); master != int64(len(set)) || forwarded != int64(len(set)) {
+			t.Errorf("Offsets don't match: %d, %d != %d (test %s)", master, forwarded, len(set), test.description)
+		}
+	}
+}
+
+func TestAddrs(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+
+	if addrs := p.Addrs(); len(addrs) != 0 {
+		t.Errorf("No addresses expected before Serve: %v", addrs)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.Serve(ln)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(p.Addrs()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	addrs := p.Addrs()
+	if len(addrs) != 1 || addrs[0].String() != ln.Addr().String() || strings.HasSuffix(addrs[0].String(), ":0") {
+		t.Errorf("Addresses don't match listener %s: %v", ln.Addr(), addrs)
+	}
+
+	p.Close()
+	<-done
+}
+
+func TestServeSeveralListeners(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+
+	done := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Unable to listen: %v", err)
+		}
+
+		go func(ln net.Listener) {
+			p.Serve(ln)
+			done <- struct{}{}
+		}(ln)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	p.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Serve should return after proxy is closed")
+		}
+	}
+
+	// listener served after Close is closed right away
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	p.Serve(ln)
+
+	if _, err = ln.Accept(); err == nil {
+		t.Errorf("Listener should be closed")
+	}
+}
+
+func runSlaveWriterBenchmark(b *testing.B, batchFlushes bool) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatalf("Unable to connect: %v", err)
+	}
+	defer conn.Close()
+
+	command := encodeRedisCommand("SET", "key", "value")
+	slavechannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(newConnSink(conn, bufSize), 0, cancel)
+	output.batchFlushes = batchFlushes
+	go slaveWriter(ctx, output, slavechannel)
+
+	b.SetBytes(int64(len(command)))
+	for i := 0; i < b.N; i++ {
+		slavechannel <- command
+		slavechannel <- nil
+	}
+	close(slavechannel)
+	<-output.done
+}
+
+func BenchmarkSlaveWriterFlushEach(b *testing.B) {
+	runSlaveWriterBenchmark(b, false)
+}
+
+func BenchmarkSlaveWriterBatchFlushes(b *testing.B) {
+	runSlaveWriterBenchmark(b, true)
+}
+
+func TestOnce(t *testing.T) {
+	master, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer master.Close()
+
+	go func() {
+		for {
+			conn, err := master.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	p := NewProxy("tcp", master.Addr().String())
+	p.Once = true
+	p.ShutdownTimeout = 100 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.Serve(ln)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write(encodeRedisCommand("REPLCONF", "ACK", "0"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Proxy should be closed once slave reaches command stream")
+	}
+}
+
+func TestSlaveAllow(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		args, _, err := ParseCommand(bufio.NewReader(conn))
+		if err != nil || len(args) != 2 || args[0] != "auth" {
+			conn.Write([]byte("-ERR expected AUTH\r\n"))
+			return
+		}
+		conn.Write([]byte("+OK\r\n"))
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+	p.SlaveAllow = []string{"AUTH"}
+
+	server, client := net.Pipe()
+
+	p.sessionStarted(server)
+	done := make(chan struct{})
+	go func() {
+		p.slaveReader(server)
+		close(done)
+	}()
+
+	reader := bufio.NewReader(client)
+
+	client.Write(encodeRedisCommand("FLUSHALL"))
+	reply, err := reader.ReadString('\n')
+	if err != nil || reply != "-ERR unknown command 'FLUSHALL'\r\n" {
+		t.Errorf("Output not equal to expected %#v != %#v (%v)", "-ERR unknown command 'FLUSHALL'\r\n", reply, err)
+	}
+
+	client.Write(encodeRedisCommand("auth", "secret"))
+	reply, err = reader.ReadString('\n')
+	if err != nil || reply != "+OK\r\n" {
+		t.Errorf("Output not equal to expected %#v != %#v (%v)", "+OK\r\n", reply, err)
+	}
+
+	client.Close()
+	<-done
+}
+
+// failingSink fails every write, like slave connection which is gone
+type failingSink struct {
+	closed chan struct{}
+}
+
+func (sink *failingSink) Write(data []byte) (int, error) {
+	return 0, fmt.Errorf("broken pipe")
+}
+
+func (sink *failingSink) Flush() error {
+	return nil
+}
+
+func (sink *failingSink) Close() error {
+	close(sink.closed)
+	return nil
+}
+
+func TestSlaveWriteFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	masterClosed := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer close(masterClosed)
+
+		// stream doesn't stop until connection is closed by proxy
+		for {
+			_, err = conn.Write(encodeRedisCommand("SET", "key", "value"))
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+
+	sink := &failingSink{closed: make(chan struct{})}
+	slavechannel := make(chan []byte, channelBuffer)
+	masterchannel := make(chan []byte, channelBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := newSlaveOutput(sink, 0, cancel)
+	go slaveWriter(ctx, output, slavechannel)
+
+	finished := make(chan struct{})
+	go func() {
+		p.masterConnection(ctx, sink, output, slavechannel, masterchannel, &syncRequest{}, &replicationOffset{})
+		close(finished)
+	}()
+
+	for _, ch := range []chan struct{}{sink.closed, finished, masterClosed} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("Slave write failure should close sink and master connection")
+		}
+	}
+}
+
+func TestSlaveSessionNoGoroutineLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+
+	masterDone := make(chan struct{})
+	go func() {
+		defer close(masterDone)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// RDB & command stream, then master waits until proxy closes connection
+		rdb := "REDIS0006\xfe\x00\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+		fmt.Fprintf(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 1\r\n$%d\r\n%s", len(rdb), rdb)
+		conn.Write(encodeRedisCommand("SET", "a_2", "value"))
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	p := NewProxy("tcp", ln.Addr().String())
+
+	server, client := net.Pipe()
+	p.sessionStarted(server)
+
+	done := make(chan struct{})
+	go func() {
+		p.slaveReader(server)
+		close(done)
+	}()
+
+	client.Write(encodeRedisCommand("SYNC"))
+
+	reader := bufio.NewReader(client)
+	for {
+		command, err := readRedisCommand(reader, DefaultMaxArgumentLength)
+		if err != nil {
+			t.Fatalf("Unable to read from proxy: %v", err)
+		}
+		if command.bulkSize > 0 {
+			reader.Discard(int(command.bulkSize))
+		}
+		if len(command.command) > 0 && command.command[0] == "SET" {
+			break
+		}
+	}
+
+	client.Close()
+	<-done
+	<-masterDone
+	ln.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		buf := make([]byte, 1<<16)
+		t.Errorf("Goroutines leaked after slave session: %d before, %d after\n%s", before, after, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestFilterRDBTooLong(t *testing.T) {
+	const (
+		keys   = "\x00\x03a_1\x04lala\x00\x03b_1\x04kuku\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+		rdb    = "REDIS0006\xfe\x00" + keys
+		rdbDB5 = "REDIS0009\xfa\x0erepl-stream-db\xc0\x05\xfe\x05" + keys
+	)
+
+	tests := []struct {
+		description string
+		rdb         string
+		prefixes    []string
+		rewrite     string
+		dbMap       map[int]int
+		shouldFail  bool
+	}{
+		{"1: Renamed key fits into space of dropped key", rdb, []string{"a_"}, "/^/xx:/", nil, false},
+		{"2: All keys renamed to longer ones", rdb, []string{"a_", "b_"}, "/^/xx:/", nil, true},
+		{"3: Keys renamed to shorter ones", rdb, []string{"a_", "b_"}, "/_//", nil, false},
+		{"4: Database remapped to number taking more bytes", rdbDB5, []string{"a_", "b_"}, "", map[int]int{5: 300}, true},
+		{"5: Remapped database fits into space of dropped key", rdbDB5, []string{"a_"}, "", map[int]int{5: 300}, false},
+	}
+
+	for _, test := range tests {
+		rdb := test.rdb
+
+		p := NewProxy("tcp", "localhost:6379")
+		p.Matcher = PrefixMatcher(test.prefixes)
+		if test.rewrite != "" {
+			p.Rewriter, _ = ParseRewrite(test.rewrite)
+		}
+		p.DBMap = test.dbMap
+
+		var output bytes.Buffer
+
+		_, _, err := p.filterRDB(bufio.NewReader(strings.NewReader(rdb)), &output, int64(len(rdb)), true, "", false)
+		if test.shouldFail {
+			if err != ErrRDBTooLong {
+				t.Errorf("Should have failed with ErrRDBTooLong: %v (test %s)", err, test.description)
+			}
+			if output.Len() > len(rdb) {
+				t.Errorf("Output is longer than original: %d > %d (test %s)", output.Len(), len(rdb), test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+		} else if output.Len() != len(rdb) {
+			t.Errorf("Output should be padded to original length: %d != %d (test %s)", output.Len(), len(rdb), test.description)
+		}
+	}
+}
+
+func TestFilterRDBMinSize(t *testing.T) {
+	const (
+		small   = "\x00\x03a_1\x01v"
+		set     = "\x02\x03a_3\x03\x01x\x01y\x01z"
+		intset  = "\x0b\x03a_4\x0e\x02\x00\x00\x00\x03\x00\x00\x00\x01\x00\x02\x00\x03\x00"
+		dropped = "\x00\x03b_1\x40\x64"
+	)
+	big := "\x00\x03a_2\x40\x64" + strings.Repeat("x", 100)
+
+	rdb := "REDIS0006\xfe\x00" + small + big + set + intset + dropped + strings.Repeat("x", 100) + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	tests := []struct {
+		description  string
+		minValueSize int
+		minElements  int
+		expected     string
+	}{
+		{"1: No thresholds", 0, 0, small + big + set + intset},
+		{"2: Minimum size", 50, 0, big},
+		{"3: Minimum elements, generic & compact encodings", 0, 3, set + intset},
+		{"4: Either threshold", 50, 3, big + set + intset},
+		{"5: Nothing big enough", 1000, 10, ""},
+	}
+
+	for _, test := range tests {
+		p := NewProxy("tcp", "localhost:6379")
+		p.Matcher = PrefixMatcher{"a_"}
+		p.MinValueSize = test.minValueSize
+		p.MinElements = test.minElements
+
+		var output bytes.Buffer
+
+		_, _, err := p.filterRDB(bufio.NewReader(strings.NewReader(rdb)), &output, 0, false, "", false)
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+			continue
+		}
+
+		expected := "REDIS0006\xfe\x00" + test.expected + "\xff"
+		received := output.String()
+		if received[:len(received)-8] != expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", expected, received[:len(received)-8], test.description)
+		}
+	}
+}
+
+func TestSizeThresholdsStream(t *testing.T) {
+	// commands for keys dropped from RDB as too small would be streamed to slave, so thresholds are refused
+	p := NewProxy("tcp", "localhost:6379")
+	p.MinElements = 10
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	if err = p.Serve(ln); err != ErrSizeThresholds {
+		t.Errorf("Serve should refuse thresholds: %v", err)
+	}
+
+	if err = p.ReplicateToSink(&bufferSink{}); err != ErrSizeThresholds {
+		t.Errorf("ReplicateToSink should refuse thresholds: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package resharding
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IntRange is inclusive range of integers, e.g. hash slots or database numbers
+type IntRange struct {
+	From, To int
+}
+
+// ParseRanges parses list of ranges like 0-5460,10000,10001-10100, bounds are checked against 0-max
+func ParseRanges(spec string, kind string, max int) ([]IntRange, error) {
+	var result []IntRange
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s range %q: %v", kind, part, err)
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse %s range %q: %v", kind, part, err)
+			}
+		}
+
+		if from < 0 || to > max || from > to {
+			return nil, fmt.Errorf("Out of bounds %s range %q, should be within 0-%d", kind, part, max)
+		}
+
+		result = append(result, IntRange{From: from, To: to})
+	}
+
+	return result, nil
+}
+
+// RangesContain checks whether value falls into any of the ranges
+func RangesContain(ranges []IntRange, value int) bool {
+	for _, r := range ranges {
+		if value >= r.From && value <= r.To {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,44 @@
+package resharding
+
+import (
+	"time"
+)
+
+// rateLimiter is a token bucket limiting throughput in bytes per second,
+// bucket holds up to one second worth of tokens, so short bursts pass without delay
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	limiter := &rateLimiter{
+		rate:  float64(bytesPerSecond),
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+	limiter.tokens = limiter.rate
+	limiter.last = limiter.now()
+
+	return limiter
+}
+
+// Wait blocks until n bytes could be sent
+func (limiter *rateLimiter) Wait(n int) {
+	now := limiter.now()
+
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.rate {
+		limiter.tokens = limiter.rate
+	}
+	limiter.last = now
+
+	limiter.tokens -= float64(n)
+	if limiter.tokens < 0 {
+		limiter.sleep(time.Duration(-limiter.tokens / limiter.rate * float64(time.Second)))
+	}
+}
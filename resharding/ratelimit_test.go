@@ -0,0 +1,40 @@
+package resharding
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var slept time.Duration
+
+	limiter := newRateLimiter(1000)
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	limiter.last = now
+
+	// burst of one second worth of data passes immediately
+	limiter.Wait(1000)
+	if slept != 0 {
+		t.Errorf("Burst shouldn't be delayed, slept %v", slept)
+	}
+
+	limiter.Wait(500)
+	if slept != 500*time.Millisecond {
+		t.Errorf("Should have slept 500ms, slept %v", slept)
+	}
+
+	// after idle period bucket is refilled, but not above one second worth of tokens
+	now = now.Add(10 * time.Second)
+	slept = 0
+
+	limiter.Wait(1000)
+	limiter.Wait(100)
+	if slept != 100*time.Millisecond {
+		t.Errorf("Should have slept 100ms, slept %v", slept)
+	}
+}
@@ -0,0 +1,1601 @@
+package resharding
+
+// Filter RDB file per spec: https://github.com/sripathikrishnan/redis-rdb-tools/wiki/Redis-RDB-Dump-File-Format
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rdbOpDB         = 0xFE
+	rdbOpExpirySec  = 0xFD
+	rdbOpExpiryMSec = 0xFC
+	rdbOpEOF        = 0xFF
+	rdbOpResizeDB   = 0xFB
+	rdbOpAux        = 0xFA
+	rdbOpFreq       = 0xF9
+	rdbOpIdle       = 0xF8
+	rdbOpModuleAux  = 0xF7
+	rdbOpFunction2  = 0xF5
+
+	rdbLen6Bit  = 0x0
+	rdbLen14bit = 0x1
+	rdbLen32Bit = 0x2
+	rdbLenEnc   = 0x3
+	rdbLen64Bit = 0x81
+
+	rdbOpString    = 0x00
+	rdbOpList      = 0x01
+	rdbOpSet       = 0x02
+	rdbOpZset      = 0x03
+	rdbOpHash      = 0x04
+	rdbOpZset2     = 0x05
+	rdbOpZipmap    = 0x09
+	rdbOpZiplist   = 0x0a
+	rdbOpIntset    = 0x0b
+	rdbOpSortedSet = 0x0c
+	rdbOpHashmap   = 0x0d
+	rdbOpQuicklist = 0x0e
+	// streams: RDB version 9+, consumer group details were added in versions 10 & 11
+	rdbOpStreamListpacks  = 0x0f
+	rdbOpStreamListpacks2 = 0x13
+	rdbOpStreamListpacks3 = 0x15
+	// listpack encodings, RDB version 10+
+	rdbOpHashListpack = 0x10
+	rdbOpZsetListpack = 0x11
+	rdbOpQuicklist2   = 0x12
+	rdbOpSetListpack  = 0x14
+
+	// quicklist (version 2) node holding single big element instead of listpack
+	rdbQuicklistNodePlain = 1
+)
+
+// Module value opcodes (module aux data is serialized as sequence of opcodes & values)
+const (
+	rdbModuleOpEOF    = 0
+	rdbModuleOpSInt   = 1
+	rdbModuleOpUInt   = 2
+	rdbModuleOpFloat  = 3
+	rdbModuleOpDouble = 4
+	rdbModuleOpString = 5
+)
+
+// Names of value types by opcode
+var rdbTypeNames = map[byte]string{
+	rdbOpString:    "string",
+	rdbOpList:      "list",
+	rdbOpSet:       "set",
+	rdbOpZset:      "zset",
+	rdbOpHash:      "hash",
+	rdbOpZset2:     "zset",
+	rdbOpZipmap:    "hash",
+	rdbOpZiplist:   "list",
+	rdbOpIntset:    "set",
+	rdbOpSortedSet: "zset",
+	rdbOpHashmap:   "hash",
+	rdbOpQuicklist: "list",
+
+	rdbOpHashListpack: "hash",
+	rdbOpZsetListpack: "zset",
+	rdbOpQuicklist2:   "list",
+	rdbOpSetListpack:  "set",
+
+	rdbOpStreamListpacks:  "stream",
+	rdbOpStreamListpacks2: "stream",
+	rdbOpStreamListpacks3: "stream",
+}
+
+var (
+	rdbSignature = []byte{0x52, 0x45, 0x44, 0x49, 0x53}
+)
+
+var (
+	// ErrWrongSignature is returned when RDB signature can't be parsed
+	ErrWrongSignature = errors.New("rdb: wrong signature")
+	// ErrVersionUnsupported is returned when RDB version is too high (can't parse)
+	ErrVersionUnsupported = errors.New("rdb: version unsupported")
+	// ErrRedisVersionUnsupported is returned in strict mode when RDB was produced by Redis newer than supported
+	ErrRedisVersionUnsupported = errors.New("rdb: redis version unsupported")
+	// ErrUnsupportedOp is returned when unsupported operation is encountered in RDB
+	ErrUnsupportedOp = errors.New("rdb: unsupported opcode")
+	// ErrUnsupportedStringEnc is returned when unsupported string encoding is encountered in RDB
+	ErrUnsupportedStringEnc = errors.New("rdb: unsupported string encoding")
+	// ErrCorruptedLZF is returned when LZF compressed string can't be decompressed
+	ErrCorruptedLZF = errors.New("rdb: corrupted LZF compressed string")
+	// ErrChecksumMismatch is returned when CRC64 checksum of source RDB doesn't match
+	ErrChecksumMismatch = errors.New("rdb: checksum mismatch")
+	// ErrUnsupportedModuleOp is returned when module aux data contains unknown opcode
+	ErrUnsupportedModuleOp = errors.New("rdb: unsupported module opcode")
+	// ErrLengthOverflow is returned when 64-bit length doesn't fit into length of string or collection
+	ErrLengthOverflow = errors.New("rdb: length overflow")
+	// ErrEOFMarkMismatch is returned when diskless RDB transfer isn't terminated with EOF mark
+	ErrEOFMarkMismatch = errors.New("rdb: EOF mark mismatch")
+	// ErrRDBTooLong is returned when filtered RDB (e.g. with keys renamed to longer ones) doesn't fit
+	// into original length, which has been announced to slave already
+	ErrRDBTooLong = errors.New("rdb: filtered RDB is longer than original")
+)
+
+// maximum RDB version filter is able to parse
+const rdbMaxVersion = 11
+
+// newest Redis (major, minor) version with known RDB format, newer versions may
+// use opcodes filter doesn't know without bumping RDB version
+var rdbMaxRedisVersion = [2]int{7, 2}
+
+// Entry buffer bigger than that is released after the entry is written, not reused
+const maxSavedReuse = 1 << 20
+
+// OpError describes unsupported opcode encountered in RDB, it helps to tell
+// RDB version mismatch from corrupted stream
+type OpError struct {
+	Op      byte
+	Offset  int64
+	Version int
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("%v 0x%02x at offset %d (RDB version %d)", ErrUnsupportedOp, e.Op, e.Offset, e.Version)
+}
+
+// Unwrap returns ErrUnsupportedOp
+func (e *OpError) Unwrap() error {
+	return ErrUnsupportedOp
+}
+
+// RDBEntry describes key with its value, it is passed to filter callback once entry is read
+type RDBEntry struct {
+	Key string
+	// Type of value: string, list, set, zset or hash
+	Type string
+	DB   int
+	// Expiry is expiration time in milliseconds since epoch, 0 if key doesn't expire
+	Expiry int64
+	// Length is number of elements in list, set or zset, number of fields in hash or number of entries
+	// in stream, it is 0 for strings; for compact encodings (ziplist, listpack, intset, zipmap) it is
+	// taken from their headers, which cap counts at 65535 entries (254 for zipmap)
+	Length int
+	// Size is approximate size of entry in RDB, bytes
+	Size int
+}
+
+// KeyFilter converts key matching function to RDB entry filter
+func KeyFilter(match func(key string) bool) func(RDBEntry) bool {
+	return func(entry RDBEntry) bool {
+		return match(entry.Key)
+	}
+}
+
+// RDBFilter holds internal state of RDB filter while running
+type RDBFilter struct {
+	reader    *bufio.Reader
+	output    io.Writer
+	dissector func(RDBEntry) bool
+	rename    func(string) string
+	// entryDone is called for every entry once decision is made, Size of entry is its size in source RDB
+	entryDone func(entry RDBEntry, kept bool)
+	// offset in source RDB where current entry (with its expiry & metadata) starts
+	entryOffset int64
+	// keptEntry is called for every kept entry with its final key and value (type byte & serialized value),
+	// entry is still written to output; it doesn't work with streaming of oversized entries
+	keptEntry      func(entry RDBEntry, value []byte) error
+	originalLength int64
+	length         int64
+	hash           uint64
+	saved          []byte
+	rdbVersion     int
+	valueState     state
+	shouldKeep     bool
+	currentOp      byte
+	currentKey     string
+	keyStart       int
+	keyEnd         int
+	expiry         int64
+	entryLength    int
+	inEntry        bool
+	offset         int64
+	verify         bool
+	// strict rejects RDB produced by Redis newer than rdbMaxRedisVersion instead of warning
+	strict     bool
+	sourceHash uint64
+	db         int
+	dbFilter   func(db int) bool
+	// database selected in replication stream when RDB was produced (repl-stream-db AUX field), -1 if unknown
+	streamDB int
+	// databases are renumbered in output according to dbMap, dbFilter & streamDB refer to source numbers
+	dbMap        map[int]int
+	eofMark      string
+	memberFilter func(member string) bool
+	keys         int64
+	// entries bigger than maxValueSize are skipped, or streamed if streamOversized is set
+	maxValueSize    int
+	streamOversized bool
+	streaming       bool
+	streamErr       error
+	// progress is called every progressInterval with number of bytes read & keys seen so far
+	progress         func(offset, keys int64)
+	progressInterval time.Duration
+}
+
+type state func(filter *RDBFilter) (nextstate state, err error)
+
+// FilterRDB filters RDB file which is read from reader, writing kept entries directly to output
+// dissector function is applied to entries to check whether item should be kept or skipped,
+// KeyFilter could be used to filter by key only; kept values are copied in encoding they were read in
+// length is original length of RDB file
+func FilterRDB(reader *bufio.Reader, output io.Writer, dissector func(RDBEntry) bool, length int64) (err error) {
+	return newRDBFilter(reader, output, dissector, length).run()
+}
+
+// ExtractRDB filters RDB file like FilterRDB, but output is not padded up to original length,
+// so it could be saved as standalone RDB file
+func ExtractRDB(reader *bufio.Reader, output io.Writer, dissector func(RDBEntry) bool) error {
+	return FilterRDB(reader, output, dissector, 0)
+}
+
+func newRDBFilter(reader *bufio.Reader, output io.Writer, dissector func(RDBEntry) bool, length int64) *RDBFilter {
+	return &RDBFilter{
+		reader:         reader,
+		output:         output,
+		dissector:      dissector,
+		originalLength: length,
+		shouldKeep:     true,
+		streamDB:       -1,
+	}
+}
+
+// Run filter until RDB is finished
+func (filter *RDBFilter) run() (err error) {
+	state := stateMagic
+	lastProgress := time.Now()
+
+	for steps := 0; state != nil; steps++ {
+		state, err = state(filter)
+		if err != nil {
+			return
+		}
+
+		// clock is checked only once in a while, as states are processed very quickly
+		if filter.progress != nil && steps%1024 == 0 && time.Since(lastProgress) >= filter.progressInterval {
+			filter.progress(filter.offset, filter.keys)
+			lastProgress = time.Now()
+		}
+	}
+
+	return nil
+}
+
+// Read exactly n bytes
+func (filter *RDBFilter) safeRead(n uint32) (result []byte, err error) {
+	result = make([]byte, n)
+	read, err := io.ReadFull(filter.reader, result)
+	filter.offset += int64(read)
+	if filter.verify {
+		filter.sourceHash = CRC64Update(filter.sourceHash, result[:read])
+	}
+	return
+}
+
+// Read single byte
+func (filter *RDBFilter) readByte() (byte, error) {
+	b, err := filter.reader.ReadByte()
+	if err == nil {
+		filter.offset++
+		if filter.verify {
+			filter.sourceHash = CRC64Update(filter.sourceHash, []byte{b})
+		}
+	}
+	return b, err
+}
+
+// Copy n bytes in chunks, so that big strings are never read as a whole
+func (filter *RDBFilter) copyBytes(n uint32) error {
+	for n > 0 {
+		chunk := n
+		if chunk > bufSize {
+			chunk = bufSize
+		}
+
+		data, err := filter.safeRead(chunk)
+		if err != nil {
+			return err
+		}
+		filter.write(data)
+
+		n -= chunk
+	}
+
+	return nil
+}
+
+// Accumulate some data that might be either filtered out or passed through
+func (filter *RDBFilter) write(data []byte) {
+	if !filter.shouldKeep {
+		return
+	}
+
+	if filter.streaming {
+		if filter.streamErr == nil {
+			filter.streamErr = filter.flush(data)
+		}
+		return
+	}
+
+	if filter.saved == nil {
+		filter.saved = make([]byte, 0, 4096)
+	}
+	filter.saved = append(filter.saved, data...)
+
+	if filter.maxValueSize > 0 && filter.inEntry && len(filter.saved) > filter.maxValueSize {
+		filter.oversizedEntry()
+	}
+}
+
+// Handle entry which has grown over maxValueSize: either skip it or decide on it early and
+// stream the rest of it directly to output
+//
+// Entries with members being filtered are always skipped, as member count precedes members.
+func (filter *RDBFilter) oversizedEntry() {
+	if !filter.streamOversized || filter.memberFilter != nil {
+		logWarn("Key %q is bigger than %d bytes, skipping it", filter.currentKey, filter.maxValueSize)
+		filter.shouldKeep = false
+		filter.saved = nil
+		return
+	}
+
+	logWarn("Key %q is bigger than %d bytes, streaming it", filter.currentKey, filter.maxValueSize)
+	filter.decideEntry()
+	filter.inEntry = false
+
+	if filter.shouldKeep {
+		filter.streamErr = filter.flush(filter.saved)
+		filter.saved = nil
+		filter.streaming = true
+	}
+}
+
+// Write data to output, updating checksum & length
+func (filter *RDBFilter) flush(data []byte) error {
+	if filter.originalLength > 0 && filter.length+int64(len(data)) > filter.originalLength {
+		return ErrRDBTooLong
+	}
+
+	_, err := filter.output.Write(data)
+	filter.hash = CRC64Update(filter.hash, data)
+	filter.length += int64(len(data))
+	return err
+}
+
+// Discard or keep saved data, kept data is written to output
+//
+// Complete entry is passed to dissector to decide whether it should be kept
+func (filter *RDBFilter) keepOrDiscard() error {
+	var keptErr error
+	if filter.inEntry {
+		filter.decideEntry()
+
+		if filter.keptEntry != nil && filter.shouldKeep {
+			key := filter.currentKey
+			if filter.rename != nil {
+				key = filter.rename(key)
+			}
+			value := append([]byte{filter.currentOp}, filter.saved[filter.keyEnd:]...)
+			keptErr = filter.keptEntry(RDBEntry{
+				Key:    key,
+				Type:   rdbTypeNames[filter.currentOp],
+				DB:     filter.db,
+				Expiry: filter.expiry,
+				Length: filter.entryLength,
+				Size:   len(value),
+			}, value)
+		}
+	}
+	filter.inEntry = false
+	filter.expiry = 0
+	filter.entryLength = 0
+	filter.streaming = false
+
+	err := filter.streamErr
+	filter.streamErr = nil
+	if err == nil {
+		err = keptErr
+	}
+	if err == nil && filter.shouldKeep && len(filter.saved) > 0 {
+		err = filter.flush(filter.saved)
+	}
+
+	// buffer is reused between entries unless it has grown on some big value
+	if cap(filter.saved) > maxSavedReuse {
+		filter.saved = nil
+	} else {
+		filter.saved = filter.saved[:0]
+	}
+	filter.shouldKeep = true
+	filter.entryOffset = filter.offset
+
+	return err
+}
+
+// Decide whether entry which has been read should be kept, renaming its key if necessary
+func (filter *RDBFilter) decideEntry() {
+	if filter.shouldKeep {
+		filter.shouldKeep = filter.dissector(RDBEntry{
+			Key:    filter.currentKey,
+			Type:   rdbTypeNames[filter.currentOp],
+			DB:     filter.db,
+			Expiry: filter.expiry,
+			Length: filter.entryLength,
+			Size:   len(filter.saved),
+		})
+	}
+
+	if filter.shouldKeep && filter.rename != nil {
+		if renamed := filter.rename(filter.currentKey); renamed != filter.currentKey {
+			value := append([]byte(nil), filter.saved[filter.keyEnd:]...)
+			filter.saved = append(append(filter.saved[:filter.keyStart], encodeString(renamed)...), value...)
+			filter.keyEnd = len(filter.saved) - len(value)
+		}
+	}
+
+	if filter.entryDone != nil {
+		filter.entryDone(RDBEntry{
+			Key:    filter.currentKey,
+			Type:   rdbTypeNames[filter.currentOp],
+			DB:     filter.db,
+			Expiry: filter.expiry,
+			Length: filter.entryLength,
+			Size:   int(filter.offset - filter.entryOffset),
+		}, filter.shouldKeep)
+	}
+}
+
+// Read length encoded prefix
+func (filter *RDBFilter) readLength() (length uint32, encoding int8, err error) {
+	long, encoding, err := filter.readLength64()
+	if err != nil {
+		return 0, 0, err
+	}
+	if long > 0xFFFFFFFF {
+		return 0, 0, ErrLengthOverflow
+	}
+	return uint32(long), encoding, nil
+}
+
+// Read length encoded prefix, which could be 64-bit (module ids, big collections)
+func (filter *RDBFilter) readLength64() (length uint64, encoding int8, err error) {
+	prefix, err := filter.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	filter.write([]byte{prefix})
+
+	if prefix == rdbLen64Bit {
+		data, err := filter.safeRead(8)
+		if err != nil {
+			return 0, 0, err
+		}
+		filter.write(data)
+		return binary.BigEndian.Uint64(data), -1, nil
+	}
+
+	kind := (prefix & 0xC0) >> 6
+
+	switch kind {
+	case rdbLen6Bit:
+		length = uint64(prefix & 0x3F)
+		return length, -1, nil
+	case rdbLen14bit:
+		data, err := filter.readByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		filter.write([]byte{data})
+		length = ((uint64(prefix) & 0x3F) << 8) | uint64(data)
+		return length, -1, nil
+	case rdbLen32Bit:
+		data, err := filter.safeRead(4)
+		if err != nil {
+			return 0, 0, err
+		}
+		filter.write(data)
+		length = uint64(binary.BigEndian.Uint32(data))
+		return length, -1, nil
+	case rdbLenEnc:
+		encoding = int8(prefix & 0x3F)
+		return 0, encoding, nil
+	}
+	panic("never reached")
+}
+
+// Whether members of collection being read should be filtered, encoded (ziplist, intset, zipmap)
+// values are always passed as a whole
+func (filter *RDBFilter) filteringMembers() bool {
+	return filter.memberFilter != nil && filter.shouldKeep
+}
+
+// Check member which has been saved starting at start, dropping it if it doesn't match
+func (filter *RDBFilter) keepMember(start int, member string) bool {
+	if !filter.shouldKeep {
+		// entry has been dropped while being read
+		return false
+	}
+
+	if filter.memberFilter(member) {
+		return true
+	}
+
+	filter.saved = filter.saved[:start]
+	return false
+}
+
+// Re-encode element count of collection, which has been saved at lengthStart:lengthEnd,
+// collection without members left is dropped entirely
+func (filter *RDBFilter) updateMemberCount(lengthStart, lengthEnd int, length, kept uint32) {
+	if !filter.shouldKeep {
+		return
+	}
+
+	if kept == 0 {
+		filter.shouldKeep = false
+		return
+	}
+
+	if kept == length {
+		return
+	}
+
+	members := append([]byte(nil), filter.saved[lengthEnd:]...)
+	filter.saved = append(append(filter.saved[:lengthStart], encodeLength(kept)...), members...)
+}
+
+// Encode length prefix
+func encodeLength(length uint32) []byte {
+	switch {
+	case length < 1<<6:
+		return []byte{byte(length)}
+	case length < 1<<14:
+		return []byte{byte(rdbLen14bit<<6) | byte(length>>8), byte(length)}
+	}
+
+	result := make([]byte, 5)
+	result[0] = rdbLen32Bit << 6
+	binary.BigEndian.PutUint32(result[1:], length)
+	return result
+}
+
+// Encode string as length-prefixed
+func encodeString(s string) []byte {
+	return append(encodeLength(uint32(len(s))), s...)
+}
+
+// Taken from Golly: https://github.com/tav/golly/blob/master/lzf/lzf.go
+// Removed part that gets outputLength from data
+func lzfDecompress(input []byte, outputLength uint32) (output []byte) {
+
+	inputLength := uint32(len(input))
+
+	var backref int64
+	var ctrl, iidx, length, oidx uint32
+
+	output = make([]byte, outputLength, outputLength)
+	iidx = 0
+
+	for iidx < inputLength {
+		// Get the control byte.
+		ctrl = uint32(input[iidx])
+		iidx++
+
+		if ctrl < (1 << 5) {
+			// The control byte indicates a literal reference.
+			ctrl++
+			if oidx+ctrl > outputLength {
+				return nil
+			}
+
+			// Safety check.
+			if iidx+ctrl > inputLength {
+				return nil
+			}
+
+			for {
+				output[oidx] = input[iidx]
+				iidx++
+				oidx++
+				ctrl--
+				if ctrl == 0 {
+					break
+				}
+			}
+		} else {
+			// The control byte indicates a back reference.
+			length = ctrl >> 5
+			backref = int64(oidx) - int64(ctrl&31)<<8 - 1
+
+			// Safety check.
+			if iidx >= inputLength {
+				return nil
+			}
+
+			// It's an extended back reference. Read the extended length before
+			// reading the full back reference location.
+			if length == 7 {
+				length += uint32(input[iidx])
+				iidx++
+				// Safety check.
+				if iidx >= inputLength {
+					return nil
+				}
+			}
+
+			// Put together the full back reference location.
+			backref -= int64(input[iidx])
+			iidx++
+
+			if oidx+length+2 > outputLength {
+				return nil
+			}
+
+			if backref < 0 {
+				return nil
+			}
+
+			output[oidx] = output[backref]
+			oidx++
+			backref++
+			output[oidx] = output[backref]
+			oidx++
+			backref++
+
+			for {
+				output[oidx] = output[backref]
+				oidx++
+				backref++
+				length--
+				if length == 0 {
+					break
+				}
+			}
+
+		}
+	}
+
+	return output
+}
+
+// read string from RDB, only uncompressed version is supported
+func (filter *RDBFilter) readString() (string, error) {
+	var result string
+
+	length, encoding, err := filter.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	switch encoding {
+	// length-prefixed string
+	case -1:
+		data, err := filter.safeRead(length)
+		if err != nil {
+			return "", err
+		}
+		filter.write(data)
+		result = string(data)
+	// integer as string
+	case 0, 1, 2:
+		data, err := filter.safeRead(1 << uint8(encoding))
+		if err != nil {
+			return "", err
+		}
+		filter.write(data)
+
+		var num uint32
+
+		if encoding == 0 {
+			num = uint32(data[0])
+		} else if encoding == 1 {
+			num = uint32(data[0]) | (uint32(data[1]) << 8)
+		} else if encoding == 2 {
+			num = uint32(data[0]) | (uint32(data[1]) << 8) | (uint32(data[2]) << 16) | (uint32(data[3]) << 24)
+		}
+
+		result = fmt.Sprintf("%d", num)
+	// compressed string
+	case 3:
+		clength, _, err := filter.readLength()
+		if err != nil {
+			return "", err
+		}
+		length, _, err := filter.readLength()
+		if err != nil {
+			return "", err
+		}
+		data, err := filter.safeRead(clength)
+		if err != nil {
+			return "", err
+		}
+		filter.write(data)
+
+		decompressed := lzfDecompress(data, length)
+		if decompressed == nil {
+			return "", ErrCorruptedLZF
+		}
+		result = string(decompressed)
+	default:
+		return "", ErrUnsupportedStringEnc
+	}
+
+	return result, nil
+}
+
+// skip (copy) string from RDB
+func (filter *RDBFilter) skipString() error {
+	length, encoding, err := filter.readLength()
+	if err != nil {
+		return err
+	}
+
+	switch encoding {
+	// length-prefixed string
+	case -1:
+		return filter.copyBytes(length)
+	// integer as string
+	case 0, 1, 2:
+		data, err := filter.safeRead(1 << uint8(encoding))
+		if err != nil {
+			return err
+		}
+		filter.write(data)
+	// compressed string
+	case 3:
+		clength, _, err := filter.readLength()
+		if err != nil {
+			return err
+		}
+		_, _, err = filter.readLength()
+		if err != nil {
+			return err
+		}
+		return filter.copyBytes(clength)
+	default:
+		return ErrUnsupportedStringEnc
+	}
+	return nil
+}
+
+// Number of entries in ziplist, from its header
+func ziplistLength(value string) int {
+	if len(value) < 10 {
+		return 0
+	}
+	return int(value[8]) | int(value[9])<<8
+}
+
+// Number of elements in listpack, from its header
+func listpackLength(value string) int {
+	if len(value) < 6 {
+		return 0
+	}
+	return int(value[4]) | int(value[5])<<8
+}
+
+// Number of elements in value of compact encoding, hash fields & values and zset members & scores
+// are stored as separate entries
+func compactLength(op byte, value string) int {
+	switch op {
+	case rdbOpZiplist:
+		return ziplistLength(value)
+	case rdbOpHashmap, rdbOpSortedSet:
+		return ziplistLength(value) / 2
+	case rdbOpSetListpack:
+		return listpackLength(value)
+	case rdbOpHashListpack, rdbOpZsetListpack:
+		return listpackLength(value) / 2
+	case rdbOpIntset:
+		if len(value) < 8 {
+			return 0
+		}
+		return int(binary.LittleEndian.Uint32([]byte(value[4:8])))
+	case rdbOpZipmap:
+		if len(value) < 1 {
+			return 0
+		}
+		return int(value[0])
+	}
+	return 0
+}
+
+// read RDB magic header
+func stateMagic(filter *RDBFilter) (state, error) {
+	signature, err := filter.safeRead(5)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Compare(signature, rdbSignature) != 0 {
+		return nil, ErrWrongSignature
+	}
+	filter.write(signature)
+
+	versionRaw, err := filter.safeRead(4)
+	if err != nil {
+		return nil, err
+	}
+	version, err := strconv.Atoi(string(versionRaw))
+	if err != nil {
+		return nil, ErrWrongSignature
+	}
+
+	logInfo("RDB version %d", version)
+
+	if version > rdbMaxVersion {
+		logError("RDB version %d is not supported, maximum supported version is %d", version, rdbMaxVersion)
+		return nil, ErrVersionUnsupported
+	}
+
+	filter.rdbVersion = version
+	filter.write(versionRaw)
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// main selector of operations
+func stateOp(filter *RDBFilter) (state, error) {
+	op, err := filter.readByte()
+	if err != nil {
+		return nil, err
+	}
+	filter.currentOp = op
+
+	switch op {
+	case rdbOpDB:
+		err = filter.keepOrDiscard()
+		if err != nil {
+			return nil, err
+		}
+		return stateDB, nil
+	case rdbOpExpirySec:
+		return stateExpirySec, nil
+	case rdbOpExpiryMSec:
+		return stateExpiryMSec, nil
+	case rdbOpIdle:
+		return stateIdle, nil
+	case rdbOpFreq:
+		return stateFreq, nil
+	case rdbOpAux, rdbOpResizeDB, rdbOpModuleAux, rdbOpFunction2:
+		// not tied to any key, always passed through
+		err = filter.keepOrDiscard()
+		if err != nil {
+			return nil, err
+		}
+		return stateAux, nil
+	case rdbOpString:
+		filter.valueState = stateSkipString
+		return stateKey, nil
+	case rdbOpZipmap, rdbOpZiplist, rdbOpIntset, rdbOpSortedSet, rdbOpHashmap,
+		rdbOpHashListpack, rdbOpZsetListpack, rdbOpSetListpack:
+		filter.valueState = stateSkipCompact
+		return stateKey, nil
+	case rdbOpList, rdbOpSet:
+		filter.valueState = stateSkipSetOrList
+		return stateKey, nil
+	case rdbOpQuicklist:
+		filter.valueState = stateSkipQuicklist
+		return stateKey, nil
+	case rdbOpQuicklist2:
+		filter.valueState = stateSkipQuicklist2
+		return stateKey, nil
+	case rdbOpZset, rdbOpZset2:
+		filter.valueState = stateSkipZset
+		return stateKey, nil
+	case rdbOpHash:
+		filter.valueState = stateSkipHash
+		return stateKey, nil
+	case rdbOpStreamListpacks, rdbOpStreamListpacks2, rdbOpStreamListpacks3:
+		filter.valueState = stateSkipStream
+		return stateKey, nil
+	case rdbOpEOF:
+		err = filter.keepOrDiscard()
+		if err != nil {
+			return nil, err
+		}
+		filter.write([]byte{rdbOpEOF})
+		err = filter.keepOrDiscard()
+		if err != nil {
+			return nil, err
+		}
+		if filter.rdbVersion > 4 {
+			return stateCRC64, nil
+		}
+		return statePadding, nil
+	default:
+		return nil, &OpError{Op: op, Offset: filter.offset - 1, Version: filter.rdbVersion}
+	}
+}
+
+// DB index operation
+func stateDB(filter *RDBFilter) (state, error) {
+	filter.write([]byte{rdbOpDB})
+	lengthStart := len(filter.saved)
+	db, _, err := filter.readLength()
+	if err != nil {
+		return nil, err
+	}
+	filter.db = int(db)
+	if target, ok := filter.dbMap[filter.db]; ok && filter.shouldKeep {
+		filter.saved = append(filter.saved[:lengthStart], encodeLength(uint32(target))...)
+	}
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// expiry is accumulated together with the key which follows it,
+// so that it is kept or dropped along with the key
+func stateExpirySec(filter *RDBFilter) (state, error) {
+	expiry, err := filter.safeRead(4)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.write([]byte{rdbOpExpirySec})
+	filter.write(expiry)
+	filter.expiry = int64(binary.LittleEndian.Uint32(expiry)) * 1000
+
+	return stateOp, nil
+}
+
+// expiry in milliseconds, kept or dropped along with the key like stateExpirySec
+func stateExpiryMSec(filter *RDBFilter) (state, error) {
+	expiry, err := filter.safeRead(8)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.write([]byte{rdbOpExpiryMSec})
+	filter.write(expiry)
+	filter.expiry = int64(binary.LittleEndian.Uint64(expiry))
+
+	return stateOp, nil
+}
+
+// LRU idle time, kept or dropped along with the key like stateExpirySec
+func stateIdle(filter *RDBFilter) (state, error) {
+	filter.write([]byte{rdbOpIdle})
+	_, _, err := filter.readLength64()
+	if err != nil {
+		return nil, err
+	}
+
+	return stateOp, nil
+}
+
+// LFU frequency, kept or dropped along with the key like stateExpirySec
+func stateFreq(filter *RDBFilter) (state, error) {
+	freq, err := filter.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	filter.write([]byte{rdbOpFreq, freq})
+
+	return stateOp, nil
+}
+
+// AUX field, RESIZEDB hint, function library or module aux data, these are
+// passed through unchanged
+func stateAux(filter *RDBFilter) (state, error) {
+	filter.write([]byte{filter.currentOp})
+
+	var err error
+
+	switch filter.currentOp {
+	case rdbOpAux:
+		// name & value
+		var name, value string
+		name, err = filter.readString()
+		if err == nil && name == "redis-ver" {
+			value, err = filter.readString()
+			if err == nil {
+				err = filter.checkRedisVersion(value)
+			}
+		} else if err == nil && name == "repl-stream-db" {
+			valueStart := len(filter.saved)
+			value, err = filter.readString()
+			if db, convErr := strconv.Atoi(value); err == nil && convErr == nil && db >= 0 {
+				filter.streamDB = db
+				// slave continues command stream in this database, so it is renumbered too
+				if target, ok := filter.dbMap[db]; ok && filter.shouldKeep {
+					filter.saved = append(filter.saved[:valueStart], encodeString(strconv.Itoa(target))...)
+				}
+			}
+		} else if err == nil {
+			err = filter.skipString()
+		}
+	case rdbOpResizeDB:
+		// db size & expires size
+		_, _, err = filter.readLength64()
+		if err == nil {
+			_, _, err = filter.readLength64()
+		}
+	case rdbOpFunction2:
+		// library code
+		err = filter.skipString()
+	case rdbOpModuleAux:
+		err = filter.skipModuleAux()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// check version of Redis which produced RDB (redis-ver AUX field), so that RDB which
+// can't be parsed is rejected before the whole transfer is done
+func (filter *RDBFilter) checkRedisVersion(version string) error {
+	logInfo("RDB produced by Redis %s", version)
+
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		logWarn("Unable to parse Redis version %q", version)
+		return nil
+	}
+
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		logWarn("Unable to parse Redis version %q", version)
+		return nil
+	}
+
+	if major < rdbMaxRedisVersion[0] || (major == rdbMaxRedisVersion[0] && minor <= rdbMaxRedisVersion[1]) {
+		return nil
+	}
+
+	if filter.strict {
+		logError("Redis %s is not supported, maximum supported version is %d.%d", version, rdbMaxRedisVersion[0], rdbMaxRedisVersion[1])
+		return ErrRedisVersionUnsupported
+	}
+
+	logWarn("Redis %s is newer than %d.%d, RDB might contain entries which can't be parsed", version, rdbMaxRedisVersion[0], rdbMaxRedisVersion[1])
+	return nil
+}
+
+// skip module aux payload: module id, when opcode & when, followed by
+// module opcodes and values up to EOF opcode
+func (filter *RDBFilter) skipModuleAux() error {
+	for i := 0; i < 3; i++ {
+		_, _, err := filter.readLength64()
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		opcode, _, err := filter.readLength64()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case rdbModuleOpEOF:
+			return nil
+		case rdbModuleOpSInt, rdbModuleOpUInt:
+			_, _, err = filter.readLength64()
+		case rdbModuleOpFloat, rdbModuleOpDouble:
+			var data []byte
+			size := uint32(4)
+			if opcode == rdbModuleOpDouble {
+				size = 8
+			}
+			data, err = filter.safeRead(size)
+			filter.write(data)
+		case rdbModuleOpString:
+			err = filter.skipString()
+		default:
+			return ErrUnsupportedModuleOp
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read key, entry is accumulated until value is read and decision could be made,
+// except for keys from databases which are filtered out
+func stateKey(filter *RDBFilter) (state, error) {
+	filter.write([]byte{filter.currentOp})
+	filter.keyStart = len(filter.saved)
+	key, err := filter.readString()
+	if err != nil {
+		return nil, err
+	}
+	filter.keyEnd = len(filter.saved)
+
+	filter.shouldKeep = filter.dbFilter == nil || filter.dbFilter(filter.db)
+	filter.currentKey = key
+	filter.inEntry = true
+	filter.keys++
+
+	return filter.valueState, nil
+}
+
+// skip over string
+func stateSkipString(filter *RDBFilter) (state, error) {
+	err := filter.skipString()
+	if err != nil {
+		return nil, err
+	}
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// skip over collection in compact encoding (ziplist, listpack, intset or zipmap), passed as a whole;
+// it is read to count elements from its header
+func stateSkipCompact(filter *RDBFilter) (state, error) {
+	value, err := filter.readString()
+	if err != nil {
+		return nil, err
+	}
+	filter.entryLength = compactLength(filter.currentOp, value)
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// skip over set or list, set members are filtered with memberFilter
+func stateSkipSetOrList(filter *RDBFilter) (state, error) {
+	lengthStart := len(filter.saved)
+	length, _, err := filter.readLength()
+	if err != nil {
+		return nil, err
+	}
+	lengthEnd := len(filter.saved)
+	filter.entryLength = int(length)
+
+	filterMembers := filter.filteringMembers() && filter.currentOp == rdbOpSet
+
+	var i, kept uint32
+
+	for i = 0; i < length; i++ {
+		if !filterMembers {
+			// list element
+			err = filter.skipString()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		memberStart := len(filter.saved)
+		member, err := filter.readString()
+		if err != nil {
+			return nil, err
+		}
+		if filter.keepMember(memberStart, member) {
+			kept++
+		}
+	}
+
+	if filterMembers {
+		filter.updateMemberCount(lengthStart, lengthEnd, length, kept)
+	}
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// skip over quicklist: list of ziplists, each passed as a whole
+func stateSkipQuicklist(filter *RDBFilter) (state, error) {
+	length, _, err := filter.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	var i uint32
+
+	for i = 0; i < length; i++ {
+		node, err := filter.readString()
+		if err != nil {
+			return nil, err
+		}
+		filter.entryLength += ziplistLength(node)
+	}
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// skip over quicklist (version 2): each node is container type followed by listpack or single plain element
+func stateSkipQuicklist2(filter *RDBFilter) (state, error) {
+	length, _, err := filter.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	var i uint32
+
+	for i = 0; i < length; i++ {
+		container, _, err := filter.readLength()
+		if err != nil {
+			return nil, err
+		}
+
+		if container == rdbQuicklistNodePlain {
+			filter.entryLength++
+			err = filter.skipString()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		node, err := filter.readString()
+		if err != nil {
+			return nil, err
+		}
+		filter.entryLength += listpackLength(node)
+	}
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// skip over hash, fields are filtered with memberFilter
+func stateSkipHash(filter *RDBFilter) (state, error) {
+	lengthStart := len(filter.saved)
+	length, _, err := filter.readLength()
+	if err != nil {
+		return nil, err
+	}
+	lengthEnd := len(filter.saved)
+	filter.entryLength = int(length)
+
+	filterMembers := filter.filteringMembers()
+
+	var i, kept uint32
+
+	for i = 0; i < length; i++ {
+		fieldStart := len(filter.saved)
+
+		// key
+		field := ""
+		if filterMembers {
+			field, err = filter.readString()
+		} else {
+			err = filter.skipString()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// value
+		err = filter.skipString()
+		if err != nil {
+			return nil, err
+		}
+
+		if filterMembers && filter.keepMember(fieldStart, field) {
+			kept++
+		}
+	}
+
+	if filterMembers {
+		filter.updateMemberCount(lengthStart, lengthEnd, length, kept)
+	}
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// skip over zset, members are filtered with memberFilter
+func stateSkipZset(filter *RDBFilter) (state, error) {
+	lengthStart := len(filter.saved)
+	length, _, err := filter.readLength()
+	if err != nil {
+		return nil, err
+	}
+	lengthEnd := len(filter.saved)
+	filter.entryLength = int(length)
+
+	filterMembers := filter.filteringMembers()
+
+	var i, kept uint32
+
+	for i = 0; i < length; i++ {
+		memberStart := len(filter.saved)
+
+		member := ""
+		if filterMembers {
+			member, err = filter.readString()
+		} else {
+			err = filter.skipString()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if filter.currentOp == rdbOpZset2 {
+			// binary double
+			double, err := filter.safeRead(8)
+			if err != nil {
+				return nil, err
+			}
+			filter.write(double)
+
+			if filterMembers && filter.keepMember(memberStart, member) {
+				kept++
+			}
+			continue
+		}
+
+		dlen, err := filter.readByte()
+		if err != nil {
+			return nil, err
+		}
+		filter.write([]byte{dlen})
+
+		if dlen < 0xFD {
+			double, err := filter.safeRead(uint32(dlen))
+			if err != nil {
+				return nil, err
+			}
+
+			filter.write(double)
+		}
+
+		if filterMembers && filter.keepMember(memberStart, member) {
+			kept++
+		}
+	}
+
+	if filterMembers {
+		filter.updateMemberCount(lengthStart, lengthEnd, length, kept)
+	}
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// Skip n length encoded numbers (stream IDs, counters)
+func (filter *RDBFilter) skipLengths(n int) error {
+	for i := 0; i < n; i++ {
+		_, _, err := filter.readLength64()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skip over stream: listpacks with entries, metadata & consumer groups, stream is passed as a whole
+func stateSkipStream(filter *RDBFilter) (state, error) {
+	// radix tree nodes: master ID (as string) & listpack
+	nodes, _, err := filter.readLength64()
+	if err != nil {
+		return nil, err
+	}
+
+	var i, j, k uint64
+
+	for i = 0; i < nodes; i++ {
+		err = filter.skipString()
+		if err != nil {
+			return nil, err
+		}
+		err = filter.skipString()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// number of entries
+	entries, _, err := filter.readLength64()
+	if err != nil {
+		return nil, err
+	}
+	filter.entryLength = int(entries)
+
+	// last ID, then first ID, max deleted ID & entries added since version 2
+	metadata := 2
+	if filter.currentOp != rdbOpStreamListpacks {
+		metadata += 5
+	}
+	err = filter.skipLengths(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, _, err := filter.readLength64()
+	if err != nil {
+		return nil, err
+	}
+
+	for i = 0; i < groups; i++ {
+		err = filter.skipString()
+		if err != nil {
+			return nil, err
+		}
+
+		// last delivered ID, then entries read since version 2
+		metadata = 2
+		if filter.currentOp != rdbOpStreamListpacks {
+			metadata++
+		}
+		err = filter.skipLengths(metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		// pending entries: raw ID, delivery time & delivery count
+		pending, _, err := filter.readLength64()
+		if err != nil {
+			return nil, err
+		}
+		for j = 0; j < pending; j++ {
+			err = filter.copyBytes(16 + 8)
+			if err != nil {
+				return nil, err
+			}
+			err = filter.skipLengths(1)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		consumers, _, err := filter.readLength64()
+		if err != nil {
+			return nil, err
+		}
+		for j = 0; j < consumers; j++ {
+			err = filter.skipString()
+			if err != nil {
+				return nil, err
+			}
+
+			// seen time, then active time since version 3
+			times := uint32(8)
+			if filter.currentOp == rdbOpStreamListpacks3 {
+				times += 8
+			}
+			err = filter.copyBytes(times)
+			if err != nil {
+				return nil, err
+			}
+
+			// pending entries of consumer: raw IDs only
+			pending, _, err := filter.readLength64()
+			if err != nil {
+				return nil, err
+			}
+			for k = 0; k < pending; k++ {
+				err = filter.copyBytes(16)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	err = filter.keepOrDiscard()
+	if err != nil {
+		return nil, err
+	}
+	return stateOp, nil
+}
+
+// re-calculate crc64, verifying source checksum if requested
+func stateCRC64(filter *RDBFilter) (state, error) {
+	sourceHash := filter.sourceHash
+
+	checksum, err := filter.safeRead(8)
+	if err != nil {
+		return nil, err
+	}
+
+	// zero checksum means checksum is disabled on master
+	expected := binary.LittleEndian.Uint64(checksum)
+	if filter.verify && expected != 0 && expected != sourceHash {
+		return nil, ErrChecksumMismatch
+	}
+
+	buf := make([]byte, 8)
+
+	if filter.originalLength > 0 && filter.length+int64(len(buf)) > filter.originalLength {
+		return nil, ErrRDBTooLong
+	}
+
+	binary.LittleEndian.PutUint64(buf, filter.hash)
+	_, err = filter.output.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+	filter.length += 8
+
+	return statePadding, nil
+}
+
+// pad RDB with 0xFF up to original length
+func statePadding(filter *RDBFilter) (state, error) {
+	const paddingSize = 4096
+
+	paddingLength := filter.originalLength - filter.length
+	paddingBlock := make([]byte, paddingSize)
+
+	for i := range paddingBlock {
+		paddingBlock[i] = 0xFF
+	}
+
+	for paddingLength > 0 {
+		if paddingLength > paddingSize {
+			_, err := filter.output.Write(paddingBlock)
+			if err != nil {
+				return nil, err
+			}
+			paddingLength -= paddingSize
+		} else {
+			_, err := filter.output.Write(paddingBlock[:paddingLength])
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if filter.eofMark != "" {
+		return stateEOFMark, nil
+	}
+	return nil, nil
+}
+
+// diskless transfer is terminated with the same mark as in the header, it is passed through
+func stateEOFMark(filter *RDBFilter) (state, error) {
+	mark, err := filter.safeRead(uint32(len(filter.eofMark)))
+	if err != nil {
+		return nil, err
+	}
+
+	if string(mark) != filter.eofMark {
+		return nil, ErrEOFMarkMismatch
+	}
+
+	_, err = filter.output.Write(mark)
+	return nil, err
+}
@@ -0,0 +1,1076 @@
+package resharding
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFilterRDB(t *testing.T) {
+	tests := []struct {
+		description   string
+		rdb           string
+		expected      string
+		expectedError error
+		filter        func(string) bool
+	}{
+		{
+			description:   "1: Simple RDB, no filter",
+			rdb:           RDBFile1,
+			expected:      RDBFile1,
+			expectedError: nil,
+			filter:        func(string) bool { return true },
+		},
+		{
+			description:   "2: Simple RDB, filter out b_",
+			rdb:           RDBFile1,
+			expected:      "REDIS0006\xfe\x00\x00\x03a_1\x04lala\x00\x03a_2\xc0!\xff\xad}0`\xa6\xf4\xa1\xab" + strings.Repeat("\xff", 56),
+			expectedError: nil,
+			filter:        func(key string) bool { return strings.HasPrefix(key, "a_") },
+		},
+		{
+			description:   "3: RDB broken, no magic",
+			rdb:           "NOTREDIS",
+			expected:      "",
+			expectedError: ErrWrongSignature,
+			filter:        func(string) bool { return true },
+		},
+		{
+			description:   "4: RDB version unsupported",
+			rdb:           "REDIS0012",
+			expected:      "",
+			expectedError: ErrVersionUnsupported,
+			filter:        func(string) bool { return true },
+		},
+		{
+			description:   "5: RDB too short",
+			rdb:           "REDIS0006\xfe\x00\x00\x03",
+			expected:      "",
+			expectedError: io.EOF,
+			filter:        func(string) bool { return true },
+		},
+		{
+			description:   "6: RDB too short",
+			rdb:           "REDIS0006\xfe",
+			expected:      "",
+			expectedError: io.EOF,
+			filter:        func(string) bool { return true },
+		},
+		{
+			description:   "7: RDB too short",
+			rdb:           "REDIS0006",
+			expected:      "",
+			expectedError: io.EOF,
+			filter:        func(string) bool { return true },
+		},
+		{
+			description:   "8: RDB too short",
+			rdb:           "REDIS00",
+			expected:      "",
+			expectedError: io.ErrUnexpectedEOF,
+			filter:        func(string) bool { return true },
+		},
+		{
+			description: "9: Old RDB, many types, no filtering",
+			rdb:         RDBFile2,
+			expected:    RDBFile2,
+			filter:      func(string) bool { return true },
+		},
+		{
+			description: "10: Old RDB, many types, fully filtered out",
+			rdb:         RDBFile2,
+			expected:    "REDIS0001\xfe\x00\xfe\x06\xfe\x07\xfe\x08\xfe\t\xfe\x0b\xfe\x0e\xfe\x0f\xff" + strings.Repeat("\xff", 1546),
+			filter:      func(string) bool { return false },
+		},
+		{
+			description: "11: Old RDB, many types, some filtered out",
+			rdb:         RDBFile2,
+			expected:    "REDIS0001\xfe\x00\xfe\x06\x02\x0bv02d_um_109\x01 86756ab85811f6603e59c6d5911c858c\x02\x0bv02e_um_108\x01 86756ab85811f6603e59c6d5911c858c\xfe\x07\xfe\x08\xfe\t\xfe\x0b\xfe\x0e\xfe\x0f\x02\x0bv02e_um_108\x01 86756ab85811f6603e59c6d5911c858c\x02\x0bv02d_um_109\x01 86756ab85811f6603e59c6d5911c858c\xff" + strings.Repeat("\xff", 1358),
+			filter:      func(key string) bool { return strings.HasPrefix(key, "v02") },
+		},
+		{
+			description: "12: RDB with list",
+			rdb:         RDBFile3,
+			expected:    RDBFile3,
+			filter:      func(key string) bool { return true },
+		},
+		{
+			description: "13: RDB with integer keys",
+			rdb:         RDBFile4,
+			expected:    "REDIS0006\xfe\x00\x00\xc0\f\x03abc\x00\u0087\xd6\x12\x00\x03fgh\xffQ\a\xb5\t\xfb\xe8ɦ\xff\xff\xff\xff\xff\xff\xff\xff",
+			filter:      func(key string) bool { return strings.HasPrefix(key, "1") },
+		},
+		{
+			description: "14: RDB with lzf compressed strings",
+			rdb:         RDBFile5,
+			expected:    "REDIS0006\xfe\x00\x00\xc3\x12/\x01aa \x00\x00d\xe0\n\x00\x00e\xe0\n\x00\x01ee\x02x3\x00\xc3\x130\x01aa\xe0\a\x00\x00b\xe0\b\x00\x00c\xe0\x00\x00\x01cc\x02x1\xff\x8f\xa2\xae٠Y\xa8N\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff",
+			filter:      func(key string) bool { return strings.HasPrefix(key, "aaaa") },
+		},
+		{
+			description: "15: RDB with compact encodings, no filtering",
+			rdb:         RDBFile6,
+			expected:    RDBFile6,
+			filter:      func(string) bool { return true },
+		},
+		{
+			description: "16: RDB with compact encodings, keep hash & list",
+			rdb:         RDBFile6,
+			expected:    "REDIS0011\xfa\x09redis-ver\x057.2.4\xfa\x0aredis-bits\xc0@\xfa\x05ctime\xc2\x00\xf1Se\xfa\x08used-mem\xc2\x00\x00\x10\x00\xfa\x08aof-base\xc0\x00\xfe\x00\xfb\x06\x01\x10\x06h_hash\x15\x15\x00\x00\x00\x04\x00\x82f1\x03\x82v1\x03\x82f2\x03\x02\x01\xff\x12\x06l_list\x01\x02\x0f\x0f\x00\x00\x00\x03\x00\x81a\x02\x81b\x02\x03\x01\xff\xff\x95\xa0\xfdBF\xba\xfb\x0a" + strings.Repeat("\xff", 108),
+			filter:      func(key string) bool { return strings.HasPrefix(key, "h_") || strings.HasPrefix(key, "l_") },
+		},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+		hadError := false
+
+		err := FilterRDB(bufio.NewReader(bytes.NewBufferString(test.rdb)), &output, KeyFilter(test.filter), int64(len(test.rdb)))
+		if err != nil {
+			if test.expectedError == nil || test.expectedError != err {
+				t.Errorf("Filtering failed (%s): %v", test.description, err)
+			} else {
+				hadError = true
+			}
+		}
+
+		received := output.String()
+
+		if test.expected != "" && len(received) != len(test.rdb) {
+			t.Errorf("Size of filtered RDB doesn't match original size: %d != %d (test %s)", len(received), len(test.rdb), test.description)
+		}
+
+		if test.expected != "" && test.expected != received {
+			t.Errorf("output not equal to expected: %#v != %#v (test %s)", test.expected, received, test.description)
+		}
+
+		if test.expectedError != nil && !hadError {
+			t.Errorf("should have failed with error %v (%s)", test.expectedError, test.description)
+		}
+	}
+
+}
+
+func TestFilterRDBUnsupportedOp(t *testing.T) {
+	err := FilterRDB(bufio.NewReader(bytes.NewBufferString("REDIS0006\xfe\x00\x00\x03a_1\x04lala\xf0")), ioutil.Discard, KeyFilter(func(string) bool { return true }), 0)
+
+	opErr, ok := err.(*OpError)
+	if !ok {
+		t.Fatalf("Should have failed with OpError: %v", err)
+	}
+
+	if opErr.Op != 0xf0 || opErr.Offset != 21 || opErr.Version != 6 {
+		t.Errorf("Error doesn't match: %#v", opErr)
+	}
+
+	if !errors.Is(err, ErrUnsupportedOp) {
+		t.Errorf("Error should wrap ErrUnsupportedOp")
+	}
+
+	if err.Error() != "rdb: unsupported opcode 0xf0 at offset 21 (RDB version 6)" {
+		t.Errorf("Error message doesn't match: %v", err)
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		length      uint32
+		expected    string
+	}{
+		{
+			description: "1: Literal only",
+			input:       "\x04hello",
+			length:      5,
+			expected:    "hello",
+		},
+		{
+			description: "2: Back references",
+			input:       "\x01aa \x00\x00d\xe0\n\x00\x00e\xe0\n\x00\x01ee",
+			length:      47,
+			expected:    strings.Repeat("a", 5) + strings.Repeat("d", 20) + strings.Repeat("e", 22),
+		},
+		{
+			description: "3: Output overflow",
+			input:       "\x04hello",
+			length:      3,
+			expected:    "",
+		},
+		{
+			description: "4: Back reference before start",
+			input:       "\x00a\x20\x05",
+			length:      10,
+			expected:    "",
+		},
+		{
+			description: "5: Back reference offset before start",
+			input:       "\x00a\x21\x00",
+			length:      10,
+			expected:    "",
+		},
+	}
+
+	for _, test := range tests {
+		output := lzfDecompress([]byte(test.input), test.length)
+		if string(output) != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", string(output), test.expected, test.description)
+		}
+	}
+}
+
+func TestFilterRDBCorruptedLZF(t *testing.T) {
+	for _, rdb := range []string{
+		"REDIS0006\xfe\x00\x00\xc3\x06\x03\x04hello",
+		"REDIS0006\xfe\x00\x00\xc3\x04\x0a\x00a\x21\x00\x00\xff",
+	} {
+		err := FilterRDB(bufio.NewReader(bytes.NewBufferString(rdb)), ioutil.Discard, KeyFilter(func(string) bool { return true }), 0)
+		if err != ErrCorruptedLZF {
+			t.Errorf("Should have failed with ErrCorruptedLZF: %v (RDB %q)", err, rdb)
+		}
+	}
+}
+
+func TestFilterRDBVerify(t *testing.T) {
+	tests := []struct {
+		description   string
+		rdb           string
+		expectedError error
+	}{
+		{
+			description: "1: Valid checksum",
+			rdb:         RDBFile1,
+		},
+		{
+			description:   "2: Corrupted value",
+			rdb:           strings.Replace(RDBFile1, "lala", "lalo", 1),
+			expectedError: ErrChecksumMismatch,
+		},
+		{
+			description: "3: Checksum disabled",
+			rdb:         "REDIS0006\xfe\x00\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00",
+		},
+	}
+
+	for _, test := range tests {
+		var received bytes.Buffer
+
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(test.rdb)), &received, KeyFilter(func(string) bool { return true }), 0)
+		filter.verify = true
+		err := filter.run()
+		output := received.String()
+
+		if err != test.expectedError {
+			t.Errorf("Error doesn't match: %v != %v (test %s)", err, test.expectedError, test.description)
+			continue
+		}
+
+		if err == nil {
+			// output checksum covers filtered payload
+			checksum := binary.LittleEndian.Uint64([]byte(output[len(output)-8:]))
+			if checksum != CRC64Update(0, []byte(output[:len(output)-8])) {
+				t.Errorf("Output checksum doesn't match (test %s)", test.description)
+			}
+		}
+	}
+}
+
+func TestFilterRDBDatabases(t *testing.T) {
+	var keys int
+
+	filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(RDBFile2)), ioutil.Discard, nil, 0)
+	filter.dbFilter = func(db int) bool { return db == 6 }
+	filter.dissector = func(entry RDBEntry) bool {
+		if entry.DB != 6 {
+			t.Errorf("Key %q from database %d shouldn't be checked", entry.Key, entry.DB)
+		}
+		keys++
+		return true
+	}
+
+	err := filter.run()
+	if err != nil {
+		t.Fatalf("Filtering failed: %v", err)
+	}
+
+	if keys == 0 {
+		t.Errorf("Keys from database 6 should have been checked")
+	}
+}
+
+func TestFilterRDBDatabaseMap(t *testing.T) {
+	const (
+		header = "REDIS0009\xfa\x0erepl-stream-db\xc0\x03"
+		db0    = "\xfe\x00\x00\x03a_1\x04lala"
+		db3    = "\x00\x03b_1\x04kuku"
+	)
+
+	rdb := header + db0 + "\xfe\x03" + db3 + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	tests := []struct {
+		description string
+		dbmap       map[int]int
+		expected    string
+	}{
+		{"1: Not mapped", nil, header + db0 + "\xfe\x03" + db3 + "\xff"},
+		{"2: Mapped database", map[int]int{3: 1}, "REDIS0009\xfa\x0erepl-stream-db\x011" + db0 + "\xfe\x01" + db3 + "\xff"},
+		{"3: Mapped into bigger number", map[int]int{3: 200}, "REDIS0009\xfa\x0erepl-stream-db\x03200" + db0 + "\xfe\x40\xc8" + db3 + "\xff"},
+		{"4: Other database mapped", map[int]int{0: 5}, header + "\xfe\x05\x00\x03a_1\x04lala" + "\xfe\x03" + db3 + "\xff"},
+	}
+
+	for _, test := range tests {
+		var received bytes.Buffer
+
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(rdb)), &received, KeyFilter(func(string) bool { return true }), 0)
+		filter.dbMap = test.dbmap
+
+		err := filter.run()
+		output := received.String()
+
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+		} else if output[:len(output)-8] != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", test.expected, output[:len(output)-8], test.description)
+		} else if filter.streamDB != 3 {
+			t.Errorf("Stream database should refer to master: %d (test %s)", filter.streamDB, test.description)
+		}
+	}
+}
+
+func TestFilterRDBExpiry(t *testing.T) {
+	const (
+		volatileA = "\xfc\xdb\x82\xb0\\B\x01\x00\x00\x00\x03a_1\x04lala"
+		volatileB = "\xfd\x10\x20\x30\x40\x00\x03b_1\x04kuku"
+		permanent = "\x00\x03a_2\x04lolo"
+	)
+
+	rdb := "REDIS0006\xfe\x00" + volatileA + volatileB + permanent + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	tests := []struct {
+		description string
+		filter      func(string) bool
+		expected    string
+	}{
+		{
+			description: "1: Volatile key kept with its expiry",
+			filter:      func(key string) bool { return strings.HasPrefix(key, "a_") },
+			expected:    "REDIS0006\xfe\x00" + volatileA + permanent + "\xff",
+		},
+		{
+			description: "2: Expiry of dropped key is dropped",
+			filter:      func(key string) bool { return strings.HasPrefix(key, "b_") },
+			expected:    "REDIS0006\xfe\x00" + volatileB + "\xff",
+		},
+	}
+
+	for _, test := range tests {
+		var received bytes.Buffer
+
+		err := ExtractRDB(bufio.NewReader(bytes.NewBufferString(rdb)), &received, KeyFilter(test.filter))
+		output := received.String()
+
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+		} else if output[:len(output)-8] != test.expected {
+			t.Errorf("output not equal to expected: %#v != %#v (test %s)", test.expected, output[:len(output)-8], test.description)
+		}
+	}
+}
+
+func TestFilterRDBAux(t *testing.T) {
+	const (
+		header    = "REDIS0009\xfa\x09redis-ver\x056.2.6\xfa\x0aredis-bits\xc0\x40"
+		function  = "\xf5\x0e#!lua name=lib"
+		moduleAux = "\xf7\x81\x00\x00\x00\x00\x00\x00\x01\x02\x02\x02" +
+			"\x05\x03abc\x04\x00\x00\x00\x00\x00\x00\xf0\x3f\x03\x00\x00\x80\x3f\x01\x05\x00"
+		db        = "\xfe\x00\xfb\x02\x00"
+		idleB     = "\xf8\x05\x00\x03b_1\x04kuku"
+		frequentA = "\xf9\x07\x00\x03a_1\x04lala"
+	)
+
+	rdb := header + function + moduleAux + db + idleB + frequentA + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	tests := []struct {
+		description string
+		filter      func(string) bool
+		expected    string
+	}{
+		{
+			description: "1: Everything kept",
+			filter:      func(string) bool { return true },
+			expected:    header + function + moduleAux + db + idleB + frequentA + "\xff",
+		},
+		{
+			description: "2: Aux fields kept, idle time dropped with its key",
+			filter:      func(key string) bool { return strings.HasPrefix(key, "a_") },
+			expected:    header + function + moduleAux + db + frequentA + "\xff",
+		},
+		{
+			description: "3: Aux fields kept when all keys are dropped",
+			filter:      func(string) bool { return false },
+			expected:    header + function + moduleAux + db + "\xff",
+		},
+	}
+
+	for _, test := range tests {
+		var received bytes.Buffer
+
+		err := ExtractRDB(bufio.NewReader(bytes.NewBufferString(rdb)), &received, KeyFilter(test.filter))
+		output := received.String()
+
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+		} else if output[:len(output)-8] != test.expected {
+			t.Errorf("output not equal to expected: %#v != %#v (test %s)", test.expected, output[:len(output)-8], test.description)
+		}
+	}
+}
+
+func TestFilterRDBAuxPreamble(t *testing.T) {
+	const (
+		preamble = "REDIS0011" +
+			"\xfa\x09redis-ver\x057.2.4" +
+			"\xfa\x0aredis-bits\xc0\x40" +
+			"\xfa\x05ctime\xc2\x00\xf1\x53\x65" +
+			"\xfa\x08used-mem\xc2\x00\x00\x10\x00" +
+			"\xfa\x0erepl-stream-db\xc0\x00" +
+			"\xfa\x07repl-id\x288de1787ba490483314a4d30f1c628bc5025eb761" +
+			"\xfa\x0brepl-offset\xc1\xe9\x03" +
+			"\xfa\x08aof-base\xc0\x00"
+		kept    = "\x00\x03a_1\x04lala"
+		dropped = "\x00\x03b_1\x04kuku"
+	)
+
+	rdb := preamble + "\xfe\x00" + kept + dropped + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	var output bytes.Buffer
+
+	err := ExtractRDB(bufio.NewReader(bytes.NewBufferString(rdb)), &output, KeyFilter(func(key string) bool { return key == "a_1" }))
+	if err != nil {
+		t.Fatalf("Filtering failed: %v", err)
+	}
+
+	// AUX fields are carried over byte for byte, in the same order
+	expected := preamble + "\xfe\x00" + kept + "\xff"
+	received := output.String()
+	if received[:len(received)-8] != expected {
+		t.Errorf("Output not equal to expected %#v != %#v", expected, received[:len(received)-8])
+	}
+
+	// filtered RDB is well-formed, with valid checksum
+	var aux []RDBEntry
+	filter := newRDBFilter(bufio.NewReader(bytes.NewReader(output.Bytes())), ioutil.Discard, func(entry RDBEntry) bool {
+		aux = append(aux, entry)
+		return true
+	}, 0)
+	filter.verify = true
+	err = filter.run()
+	if err != nil {
+		t.Errorf("Filtered RDB can't be read back: %v", err)
+	}
+	if len(aux) != 1 || aux[0].Key != "a_1" {
+		t.Errorf("Entries of filtered RDB don't match: %#v", aux)
+	}
+}
+
+func TestFilterRDBBinaryKeys(t *testing.T) {
+	const (
+		header = "REDIS0006\xfe\x00"
+		keyA   = "\x00\x04a\x00\xff1\x04lala"
+		keyB   = "\x00\x03\x00a2\x04kuku"
+	)
+
+	matcher := RegexpMatcher{regexp.MustCompile(`^a\x00\x{FFFD}`)}
+
+	var received bytes.Buffer
+	err := ExtractRDB(bufio.NewReader(bytes.NewBufferString(header+keyA+keyB+"\xff\x00\x00\x00\x00\x00\x00\x00\x00")), &received, KeyFilter(matcher.Match))
+	output := received.String()
+
+	if err != nil {
+		t.Errorf("Filtering failed: %v", err)
+	} else if output[:len(output)-8] != header+keyA+"\xff" {
+		t.Errorf("Output not equal to expected %#v != %#v", output[:len(output)-8], header+keyA+"\xff")
+	}
+}
+
+// Build stream value of given RDB type with single listpack, consumer group,
+// pending entry & consumer
+func rdbStream(op byte) string {
+	id := strings.Repeat("\x00", 8) + "\x00\x00\x00\x00\x00\x00\x00\x05"
+	ms := "\x10\x27\x00\x00\x00\x00\x00\x00"
+
+	// listpacks, number of entries & last ID (64-bit ms)
+	value := "\x01\x10" + id + "\x05lplp!" + "\x01\x81\x00\x00\x01\x8c\x00\x00\x00\x00\x00"
+	if op != rdbOpStreamListpacks {
+		// first ID, max deleted ID, entries added
+		value += "\x05\x00\x00\x00\x01"
+	}
+
+	// group with last delivered ID
+	value += "\x01\x02g1\x05\x00"
+	if op != rdbOpStreamListpacks {
+		// entries read
+		value += "\x01"
+	}
+	// pending entry & consumer
+	value += "\x01" + id + ms + "\x01" + "\x01\x02c1" + ms
+	if op == rdbOpStreamListpacks3 {
+		// active time
+		value += ms
+	}
+	value += "\x01" + id
+
+	return string([]byte{op}) + "\x03a_s" + value
+}
+
+func TestFilterRDBStreams(t *testing.T) {
+	const (
+		header = "REDIS0011\xfe\x00"
+		keyB   = "\x00\x03b_1\x04kuku"
+		keyA   = "\x00\x03a_1\x04lala"
+	)
+
+	for _, op := range []byte{rdbOpStreamListpacks, rdbOpStreamListpacks2, rdbOpStreamListpacks3} {
+		stream := rdbStream(op)
+		rdb := header + keyB + stream + keyA + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+		tests := []struct {
+			description string
+			filter      func(string) bool
+			expected    string
+		}{
+			{
+				description: fmt.Sprintf("1: Stream kept (type %d)", op),
+				filter:      func(key string) bool { return strings.HasPrefix(key, "a_") },
+				expected:    header + stream + keyA + "\xff",
+			},
+			{
+				description: fmt.Sprintf("2: Stream dropped (type %d)", op),
+				filter:      func(key string) bool { return strings.HasPrefix(key, "b_") },
+				expected:    header + keyB + "\xff",
+			},
+		}
+
+		for _, test := range tests {
+			var received bytes.Buffer
+
+			err := ExtractRDB(bufio.NewReader(bytes.NewBufferString(rdb)), &received, KeyFilter(test.filter))
+			output := received.String()
+
+			if err != nil {
+				t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+			} else if output[:len(output)-8] != test.expected {
+				t.Errorf("Output not equal to expected %#v != %#v (test %s)", test.expected, output[:len(output)-8], test.description)
+			}
+		}
+	}
+}
+
+func TestFilterRDBStreamDB(t *testing.T) {
+	tests := []struct {
+		description string
+		aux         string
+		expected    int
+	}{
+		{"1: Integer encoded", "\xfa\x0erepl-stream-db\xc0\x03", 3},
+		{"2: String encoded", "\xfa\x0erepl-stream-db\x0212", 12},
+		{"3: Not recorded", "\xfa\x09redis-ver\x057.2.4", -1},
+	}
+
+	for _, test := range tests {
+		rdb := "REDIS0009" + test.aux + "\xfe\x00\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+		var received bytes.Buffer
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(rdb)), &received, KeyFilter(func(string) bool { return true }), 0)
+
+		err := filter.run()
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+		} else if filter.streamDB != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", filter.streamDB, test.expected, test.description)
+		} else if output := received.String(); output[:len(output)-8] != rdb[:len(rdb)-8] {
+			t.Errorf("AUX field should be passed through %#v != %#v (test %s)", output[:len(output)-8], rdb[:len(rdb)-8], test.description)
+		}
+	}
+}
+
+func TestFilterRDBRedisVersion(t *testing.T) {
+	tests := []struct {
+		description   string
+		version       string
+		strict        bool
+		expectedError error
+	}{
+		{"1: Supported version", "7.2.4", true, nil},
+		{"2: Older version", "6.2.6", true, nil},
+		{"3: Newer version", "8.0.0", false, nil},
+		{"4: Newer version, strict", "7.4.0", true, ErrRedisVersionUnsupported},
+		{"5: Unparseable version", "unstable", true, nil},
+	}
+
+	for _, test := range tests {
+		rdb := "REDIS0011\xfa\x09redis-ver" + string([]byte{byte(len(test.version))}) + test.version +
+			"\xfe\x00\x00\x03a_1\x04lala\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(rdb)), ioutil.Discard, KeyFilter(func(string) bool { return true }), 0)
+		filter.strict = test.strict
+
+		err := filter.run()
+		if err != test.expectedError {
+			t.Errorf("Unexpected error %v != %v (test %s)", err, test.expectedError, test.description)
+		}
+	}
+}
+
+func TestFilterRDBEOFMark(t *testing.T) {
+	const mark = "0123456789abcdef0123456789abcdef01234567"
+
+	tests := []struct {
+		description   string
+		rdb           string
+		expectedError error
+	}{
+		{
+			description: "1: Valid mark",
+			rdb:         RDBFile1 + mark,
+		},
+		{
+			description:   "2: Wrong mark",
+			rdb:           RDBFile1 + strings.Repeat("x", len(mark)),
+			expectedError: ErrEOFMarkMismatch,
+		},
+		{
+			description:   "3: Truncated mark",
+			rdb:           RDBFile1 + mark[:10],
+			expectedError: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(test.rdb)), &output, KeyFilter(func(key string) bool { return strings.HasPrefix(key, "a_") }), 0)
+		filter.eofMark = mark
+		err := filter.run()
+
+		if err != test.expectedError {
+			t.Errorf("Error doesn't match: %v != %v (test %s)", err, test.expectedError, test.description)
+			continue
+		}
+
+		if err == nil && !strings.HasSuffix(output.String(), "\xff\xad}0`\xa6\xf4\xa1\xab"+mark) {
+			t.Errorf("Output should be terminated with checksum & EOF mark: %#v (test %s)", output.String(), test.description)
+		}
+	}
+}
+
+func TestFilterRDBNothingMatches(t *testing.T) {
+	const mark = "0123456789abcdef0123456789abcdef01234567"
+
+	tests := []struct {
+		description string
+		rdb         string
+		eofMark     string
+		length      int64
+	}{
+		{
+			description: "1: RDB with checksum",
+			rdb:         RDBFile1,
+		},
+		{
+			description: "2: RDB without checksum",
+			rdb:         RDBFile2,
+		},
+		{
+			description: "3: Padded up to original length",
+			rdb:         RDBFile1,
+			length:      int64(len(RDBFile1)),
+		},
+		{
+			description: "4: Diskless RDB",
+			rdb:         RDBFile1 + mark,
+			eofMark:     mark,
+		},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(test.rdb)), &output, KeyFilter(func(string) bool { return false }), test.length)
+		filter.eofMark = test.eofMark
+		err := filter.run()
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+			continue
+		}
+
+		if test.length > 0 && int64(output.Len()) != test.length {
+			t.Errorf("Output should be padded up to %d bytes: %d (test %s)", test.length, output.Len(), test.description)
+		}
+
+		// empty RDB should be loadable: header, EOF & valid checksum, without any keys
+		keys := 0
+		reread := newRDBFilter(bufio.NewReader(&output), ioutil.Discard, func(RDBEntry) bool { keys++; return true }, 0)
+		reread.verify = true
+		reread.eofMark = test.eofMark
+		err = reread.run()
+		if err != nil {
+			t.Errorf("Output isn't valid RDB: %v (test %s)", err, test.description)
+		}
+		if keys != 0 {
+			t.Errorf("Output shouldn't contain any keys: %d (test %s)", keys, test.description)
+		}
+	}
+}
+
+func TestFilterRDBMembers(t *testing.T) {
+	const (
+		hash = "\x04\x01h\x03\x02f1\x01a\x02x1\x01b\x02f2\x01c"
+		set  = "\x02\x01s\x02\x02f3\x02y3"
+		zset = "\x03\x01z\x02\x02y4\x011\x02y5\x012"
+		list = "\x01\x01l\x01\x02y6"
+	)
+
+	rdb := "REDIS0006\xfe\x00" + hash + set + zset + list + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	var output bytes.Buffer
+
+	filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(rdb)), &output, KeyFilter(func(string) bool { return true }), 0)
+	filter.memberFilter = func(member string) bool { return strings.HasPrefix(member, "f") }
+	err := filter.run()
+	if err != nil {
+		t.Fatalf("Filtering failed: %v", err)
+	}
+
+	// zset without matching members is dropped, list elements aren't filtered
+	expected := "REDIS0006\xfe\x00" + "\x04\x01h\x02\x02f1\x01a\x02f2\x01c" + "\x02\x01s\x01\x02f3" + list + "\xff"
+
+	received := output.String()
+	if received[:len(received)-8] != expected {
+		t.Errorf("output not equal to expected: %#v != %#v", expected, received[:len(received)-8])
+	}
+}
+
+func TestFilterRDBMaxValueSize(t *testing.T) {
+	big := "\x00\x03a_1\x40\x64" + strings.Repeat("x", 100)
+	small := "\x00\x03a_2\x04lala"
+	set := "\x02\x03a_3\x02\x02f1\x40\x64" + strings.Repeat("f", 100)
+
+	rdb := "REDIS0006\xfe\x00" + big + small + set + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	tests := []struct {
+		description  string
+		stream       bool
+		memberFilter func(string) bool
+		expected     string
+	}{
+		{
+			description: "1: Oversized keys skipped",
+			expected:    "REDIS0006\xfe\x00" + small + "\xff",
+		},
+		{
+			description: "2: Oversized keys streamed",
+			stream:      true,
+			expected:    "REDIS0006\xfe\x00" + big + small + set + "\xff",
+		},
+		{
+			description:  "3: Oversized keys skipped when members are filtered",
+			stream:       true,
+			memberFilter: func(string) bool { return true },
+			expected:     "REDIS0006\xfe\x00" + small + "\xff",
+		},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(rdb)), &output, KeyFilter(func(string) bool { return true }), 0)
+		filter.maxValueSize = 50
+		filter.streamOversized = test.stream
+		filter.memberFilter = test.memberFilter
+
+		err := filter.run()
+		received := output.String()
+
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+		} else if received[:len(received)-8] != test.expected {
+			t.Errorf("output not equal to expected: %#v != %#v (test %s)", test.expected, received[:len(received)-8], test.description)
+		}
+	}
+}
+
+func TestFilterRDBProgress(t *testing.T) {
+	var calls int
+
+	filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(RDBFile1)), ioutil.Discard, KeyFilter(func(string) bool { return true }), 0)
+	filter.progress = func(offset, keys int64) {
+		if offset <= 0 || offset > int64(len(RDBFile1)) {
+			t.Errorf("Progress offset out of range: %d", offset)
+		}
+		calls++
+	}
+
+	err := filter.run()
+	if err != nil {
+		t.Fatalf("Filtering failed: %v", err)
+	}
+
+	if calls == 0 {
+		t.Errorf("Progress should have been reported")
+	}
+
+	if filter.keys != 5 || filter.offset != int64(len(RDBFile1)) {
+		t.Errorf("Totals don't match: %d keys, %d bytes", filter.keys, filter.offset)
+	}
+}
+
+func TestFilterRDBEncodings(t *testing.T) {
+	const dropped = "\x00\x01d\x01v"
+
+	tests := []struct {
+		description string
+		entry       string
+		expected    string
+	}{
+		// small collections in compact encodings are passed as is, even if members don't match
+		{"1: Listpack hash", "\x10\x06h_hash\x15\x15\x00\x00\x00\x04\x00\x82f1\x03\x82v1\x03\x82f2\x03\x02\x01\xff", ""},
+		{"2: Listpack zset", "\x11\x06z_zset\x16\x16\x00\x00\x00\x04\x00\x82m1\x03\x01\x01\x82m2\x03\x832.5\x04\xff", ""},
+		{"3: Quicklist of listpacks", "\x12\x06l_list\x01\x02\x0f\x0f\x00\x00\x00\x03\x00\x81a\x02\x81b\x02\x03\x01\xff", ""},
+		{"4: Intset", "\x0b\x05i_set\x0e\x02\x00\x00\x00\x03\x00\x00\x00\x01\x00\x02\x00\x03\x00", ""},
+		{"5: Listpack set", "\x14\x05p_set\x0d\x0d\x00\x00\x00\x02\x00\x81x\x02\x81y\x02\xff", ""},
+		// big collections stay in generic encoding, also when some members are dropped
+		{"6: Set", "\x02\x01s\x03\x02f1\x02f2\x02f3", ""},
+		{"7: Hash", "\x04\x01h\x02\x02f1\x01a\x02f2\x01b", ""},
+		{"8: Zset with binary scores", "\x05\x01z\x02\x02f1\x00\x00\x00\x00\x00\x00\xf0?\x02f2\x00\x00\x00\x00\x00\x00\x00@", ""},
+		{"9: Hash with members dropped", "\x04\x01h\x03\x02f1\x01a\x02x1\x01b\x02f2\x01c", "\x04\x01h\x02\x02f1\x01a\x02f2\x01c"},
+	}
+
+	for _, test := range tests {
+		rdb := "REDIS0011\xfe\x00" + test.entry + dropped + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+		var output bytes.Buffer
+
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(rdb)), &output, KeyFilter(func(key string) bool { return key != "d" }), 0)
+		filter.memberFilter = func(member string) bool { return strings.HasPrefix(member, "f") }
+		err := filter.run()
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+			continue
+		}
+
+		expected := test.expected
+		if expected == "" {
+			expected = test.entry
+		}
+		expected = "REDIS0011\xfe\x00" + expected + "\xff"
+
+		received := output.String()
+		if received[:len(received)-8] != expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", expected, received[:len(received)-8], test.description)
+		}
+	}
+}
+
+func TestFilterRDBEntries(t *testing.T) {
+	const (
+		volatile = "\xfc\xdb\x82\xb0\\B\x01\x00\x00\x00\x01a\x04lala"
+		zset     = "\x03\x01z\x02\x02y4\x011\x02y5\x012"
+		set      = "\x02\x01s\x01\x02f3"
+	)
+
+	rdb := "REDIS0006\xfe\x03" + volatile + zset + set + "\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	var (
+		output  bytes.Buffer
+		entries []RDBEntry
+	)
+
+	// keep only volatile keys & collections with more than one element
+	err := ExtractRDB(bufio.NewReader(bytes.NewBufferString(rdb)), &output, func(entry RDBEntry) bool {
+		entries = append(entries, entry)
+		return entry.Expiry != 0 || entry.Length > 1
+	})
+	if err != nil {
+		t.Fatalf("Filtering failed: %v", err)
+	}
+
+	expectedEntries := []RDBEntry{
+		{Key: "a", Type: "string", DB: 3, Expiry: 1384534541019, Size: len(volatile)},
+		{Key: "z", Type: "zset", DB: 3, Length: 2, Size: len(zset)},
+		{Key: "s", Type: "set", DB: 3, Length: 1, Size: len(set)},
+	}
+	if !reflect.DeepEqual(entries, expectedEntries) {
+		t.Errorf("Entries don't match: %#v != %#v", entries, expectedEntries)
+	}
+
+	expected := "REDIS0006\xfe\x03" + volatile + zset + "\xff"
+	received := output.String()
+	if received[:len(received)-8] != expected {
+		t.Errorf("output not equal to expected: %#v != %#v", expected, received[:len(received)-8])
+	}
+}
+
+// Build ziplist string with n single character entries, header only is valid
+func rdbZiplist(n int) string {
+	return string(encodeLength(uint32(10+n*3+1))) + "\x00\x00\x00\x00\x00\x00\x00\x00" + string([]byte{byte(n), byte(n >> 8)}) +
+		strings.Repeat("\x00\x01x", n) + "\xff"
+}
+
+func TestFilterRDBEntryLength(t *testing.T) {
+	plain := "\x0a" + strings.Repeat("x", 10)
+
+	tests := []struct {
+		description string
+		rdb         string
+		expected    map[string]int
+	}{
+		{
+			description: "1: Listpack, quicklist, intset",
+			rdb:         RDBFile6,
+			expected:    map[string]int{"s_str": 0, "s_exp": 0, "h_hash": 2, "z_zset": 2, "l_list": 3, "i_set": 3, "p_set": 2},
+		},
+		{
+			description: "2: Compressed ziplist",
+			rdb:         RDBFile3,
+			expected:    map[string]int{"mylist": 100},
+		},
+		{
+			description: "3: Ziplists, zipmap, quicklist",
+			rdb: "REDIS0007\xfe\x00" +
+				"\x0a\x03a_l" + rdbZiplist(3) +
+				"\x0d\x03a_h" + rdbZiplist(4) +
+				"\x0c\x03a_z" + rdbZiplist(6) +
+				"\x09\x03a_m\x07\x02\x01f\x01\x00v\xff" +
+				"\x0e\x03a_q\x02" + rdbZiplist(2) + rdbZiplist(5) +
+				"\xff\x00\x00\x00\x00\x00\x00\x00\x00",
+			expected: map[string]int{"a_l": 3, "a_h": 2, "a_z": 3, "a_m": 2, "a_q": 7},
+		},
+		{
+			description: "4: Quicklist with plain node, stream",
+			rdb: "REDIS0011\xfe\x00" +
+				"\x12\x03a_q\x02\x01" + plain + "\x02\x0f\x0f\x00\x00\x00\x03\x00\x81a\x02\x81b\x02\x03\x01\xff" +
+				rdbStream(rdbOpStreamListpacks3) +
+				"\xff\x00\x00\x00\x00\x00\x00\x00\x00",
+			expected: map[string]int{"a_q": 4, "a_s": 1},
+		},
+	}
+
+	for _, test := range tests {
+		lengths := make(map[string]int)
+
+		err := ExtractRDB(bufio.NewReader(bytes.NewBufferString(test.rdb)), ioutil.Discard, func(entry RDBEntry) bool {
+			lengths[entry.Key] = entry.Length
+			return true
+		})
+		if err != nil {
+			t.Errorf("Filtering failed: %v (test %s)", err, test.description)
+			continue
+		}
+
+		if !reflect.DeepEqual(lengths, test.expected) {
+			t.Errorf("Lengths don't match %#v != %#v (test %s)", lengths, test.expected, test.description)
+		}
+	}
+}
+
+func TestExtractRDB(t *testing.T) {
+	var output bytes.Buffer
+
+	err := ExtractRDB(bufio.NewReader(bytes.NewBufferString(RDBFile1)), &output, KeyFilter(func(key string) bool { return strings.HasPrefix(key, "a_") }))
+	if err != nil {
+		t.Errorf("Extracting failed: %v", err)
+	}
+
+	received := output.String()
+
+	expected := "REDIS0006\xfe\x00\x00\x03a_1\x04lala\x00\x03a_2\xc0!\xff\xad}0`\xa6\xf4\xa1\xab"
+	if received != expected {
+		t.Errorf("output not equal to expected: %#v != %#v", expected, received)
+	}
+}
+
+func TestFilterRDBRename(t *testing.T) {
+	var output bytes.Buffer
+
+	filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(RDBFile1)), &output, KeyFilter(func(key string) bool { return strings.HasPrefix(key, "a_") }), int64(len(RDBFile1)))
+	filter.rename = func(key string) string { return "renamed_" + key[2:] }
+	err := filter.run()
+	if err != nil {
+		t.Errorf("Filtering failed: %v", err)
+	}
+
+	received := output.String()
+
+	expected := "REDIS0006\xfe\x00\x00\x09renamed_1\x04lala\x00\x09renamed_2\xc0!\xff"
+	crc := make([]byte, 8)
+	binary.LittleEndian.PutUint64(crc, CRC64Update(0, []byte(expected)))
+	expected += string(crc)
+	expected += strings.Repeat("\xff", len(RDBFile1)-len(expected))
+
+	if received != expected {
+		t.Errorf("output not equal to expected: %#v != %#v", expected, received)
+	}
+}
+
+func TestEncodeLength(t *testing.T) {
+	tests := []struct {
+		length   uint32
+		expected []byte
+	}{
+		{10, []byte{0x0a}},
+		{700, []byte{0x42, 0xbc}},
+		{17000, []byte{0x80, 0x00, 0x00, 0x42, 0x68}},
+	}
+
+	for _, test := range tests {
+		encoded := encodeLength(test.length)
+		if !bytes.Equal(encoded, test.expected) {
+			t.Errorf("Encoded length %d doesn't match: %#v != %#v", test.length, encoded, test.expected)
+		}
+
+		filter := newRDBFilter(bufio.NewReader(bytes.NewBuffer(encoded)), nil, nil, 0)
+		length, _, err := filter.readLength()
+		if err != nil || length != test.length {
+			t.Errorf("Decoded length doesn't match: %d != %d (%v)", length, test.length, err)
+		}
+	}
+}
+
+func runRDBBenchmark(b *testing.B, filter func(string) bool) {
+	for i := 0; i < b.N; i++ {
+		err := FilterRDB(bufio.NewReader(bytes.NewBufferString(RDBFile2)), ioutil.Discard, KeyFilter(filter), int64(len(RDBFile2)))
+		if err != nil {
+			b.Fatalf("Unable to filter RDB: %v", err)
+		}
+	}
+}
+
+func BenchmarkFilterRDBCopy(b *testing.B) {
+	runRDBBenchmark(b, func(string) bool { return true })
+}
+
+func BenchmarkFilterRDBDiscard(b *testing.B) {
+	runRDBBenchmark(b, func(string) bool { return false })
+}
+
+func BenchmarkFilterRDBSome(b *testing.B) {
+	runRDBBenchmark(b, func(key string) bool { return strings.HasPrefix(key, "v02") })
+}
+
+const (
+	RDBFile1 = "REDIS0006\xfe\x00\x00\x03b_1\x04kuku\x00\x03a_1\x04lala\x00\x03b_3\xc3\t@\xb3\x01aa\xe0\xa6\x00\x01aa\xfc\xdb\x82\xb0\\B\x01\x00\x00\x00\x03b_2\r2343545345345\x00\x03a_2\xc0!\xffT\x81\xe9\x86\xcc\x9f\x1f\xc4"
+	RDBFile2 = "REDIS0001\xfe\x00\x00\ncompressed\xc3\x0c(\x04abcda\xe0\x18\x03\x01cd\x03\x05testz\x06\x01b\x0256\x01c\x0257\x03aaa\x0277\x04dddd\x011\x01a\x0243\x02aa\x017\xfe\x06\x02\x0bv02d_um_109\x01 86756ab85811f6603e59c6d5911c858c\x02\x0bv02e_um_108\x01 86756ab85811f6603e59c6d5911c858c\xfe\x07\x00\x12v3fe_Eramu@qik.com\xc0\x07\x00*v0a0_Ugrizmo4552d32c-af1e-484c-9d0b-6e4447\xc0\x04\xfe\x08\x00\x15v8da_Enikolay@qik.com\xc0\x08\x01\tbi_webapp\x06@q{\"event\":\"webapp.user.signup\",\"method\":\"api\",\"timestamp\":1311664050.7045,\"actor_id\":159973,\"ip\":null,\"app\":\"mob\"}@r{\"event\":\"webapp.user.signup\",\"method\":\"api\",\"timestamp\":1311664056.18088,\"actor_id\":159974,\"ip\":null,\"app\":\"mob\"}@r{\"event\":\"webapp.user.signup\",\"method\":\"api\",\"timestamp\":1311664560.31115,\"actor_id\":159975,\"ip\":null,\"app\":\"mob\"}@r{\"event\":\"webapp.user.signup\",\"method\":\"api\",\"timestamp\":1311664565.91616,\"actor_id\":159976,\"ip\":null,\"app\":\"mob\"}@r{\"event\":\"webapp.user.signup\",\"method\":\"api\",\"timestamp\":1311664820.23724,\"actor_id\":159977,\"ip\":null,\"app\":\"mob\"}@r{\"event\":\"webapp.user.signup\",\"method\":\"api\",\"timestamp\":1311664860.49914,\"actor_id\":159978,\"ip\":null,\"app\":\"mob\"}\x00*v7c5_Ugrizmo15919895-bba1-47ef-8bdb-f6f968\xc0\x06\x00\x0bv693_dudeid\xc0\x08\xfe\t\x00*vd06_Ugrizmo29b59262-d286-4ed5-b7bf-1566cf\xc0\x03\x00*vf9e_Ugrizmo6b035e25-02b2-44ee-8860-48bac5\xc0\x05\x00*veaf_Ugrizmo468defb9-dc99-4cf2-92e7-cdef05\xc0\x01\x00*vc12_Ugrizmo8b2858e9-d439-4726-bb8e-abdbd9\xc0\x02\xfe\x0b\x00\x06v035_5\xc2\xe9p\x02\x00\xfe\x0e\x04\x0cv9a5_U159946\x03\x05dirty\x010\x07clients\x04\x80\x02].\x05users\x04\x80\x02].\x04\x0cv94b_U159973\x01\x05dirty\x011\x04\x0cv94a_U159974\x01\x05dirty\x011\x04\x0cv948_U159976\x01\x05dirty\x011\x04\x0cv946_U159978\x01\x05dirty\x011\x04\x0cv947_U159977\x01\x05dirty\x011\x04\x0cv949_U159975\x01\x05dirty\x011\xfe\x0f\x02\nv588_um_45\x01 f427ecf81e3afe3f4037a629944aaea0\x02\x0bv02e_um_108\x01 86756ab85811f6603e59c6d5911c858c\x02\x0bv02d_um_109\x01 86756ab85811f6603e59c6d5911c858c\xff"
+	RDBFile3 = "REDIS0006\xfe\x00\n\x06mylist\xc3A\xbeE\x83\x04\x83\x05\x00\x00t \x03\x04d\x00\x00\x0c0\xe0\x00\x00\x0270\x0e\xe0\x02\r\x0115\xe0\x03\r\x0124\xe0\x03\r\x0198\xe0\x03\r\x0137\xe0\x03\r\x008\xe0\x04)\x0119\xe0\x03\x1b\x0121\xe0\x03\r\x0173\xe0\x03\r\x002\xe0\x04)\x0142\xe0\x03\x1b\x003\xe0\x04\x1b\x009\xe0\x04a\x0186\xe0\x03)\x002\xe0\x04\r\x001\xe0\x12E\x006\xe0\x04\xc3\x007\xe0\x047\x006\xe1\x04\t\x003\xe0\x04E\x009\xe0\x04\x8b\x005\xe0\x04\x8b\x005\xe0\x04\xdf\x000\xe0\x04\xdf\x001\xe0\x04\x1b\xe1\x05%\x008\xe0\x05\x8b\xe0\x05\r\xe0\x04\x99\x000\xe0\x04\x1b\x008\xe1\x04y\x005\xe0\x04\xb5\x004\xe0\x04}\x006\xe0\x04\xa7\x003\xe0\x04\r\x006\xe0\x04E\x001\xe0\x04\x1b\x004\xe2\x04\x05\x005\xe0\x04\x8b\x008\xe1\x05\x17\xe0\x04)\xe2\x05/\x005\xe0\x05a\xe0\x04\x99\xe1\x06\xf7\xe0\x04a\x000\xe0\x04S\x003\xe0\x04\x1b\x002\xe0\x04S\xe1\x05O\x002\xe0\x047\x009\xe0\x04o\xe0\x05S\x008\xe1\x04\x95\x009\xe0\x04a\xe3\x05\x0f\x006\xe0\x04\xed\xe2\x05\xbb\xe1\x06\x17\xe1\x04\x87\x009\xe0\x04a\xe2\x06\x9f\xe0\x04\x99\xe1\x05\x87\x006\xe2\x05g\xe1\x04\xf7\x002\xe0\x04\x8b\x000\xe0\x04\r\xe1\x05O\x003\xe1\x04\t\xe0\x05a\x002\xe0\x05}\xe0\x04E\x003\xe0\x04\xc3\xe1\x05\xe9\xe0\x05a\xe0\x05S\xe3\x05U\xe2\x05\xf3\xe1\x05\xbf\x007\xe0\x04o\xe1\x05\x17\x004\xe0\x04}\xe0\x05\x1b\x006\xe0\x04\xb5\x005\xe1\x04%\x009\xe0\x057\xe2\x04!\xe0\x05\xdf\xe4\x05\x89\x004\xe0\x05E\xe0\x04\x99\xe4\x05_\xe1\x05O\xe2\x05Y\xe5\x051\x007\xe0\x04}\xe0\x05E\x0283\xff\xffy\xaa\x8e\x05\xb8\xd6\xecX"
+	RDBFile4 = "REDIS0006\xfe\x00\x00\xc1aS\x03cde\x00\xc0\x0c\x03abc\x00\xc2\x87\xd6\x12\x00\x03fgh\xff\xe9 \xb4\xe35e\x99\x92"
+	RDBFile5 = "REDIS0006\xfe\x00\x00\xc3\x12/\x01aa \x00\x00d\xe0\n\x00\x00e\xe0\n\x00\x01ee\x02x3\x00\xc3\x120\x01bb\xe0\x07\x00\x00a\xe0\t\x00\x00c\xc0\x00\x01cc\x02x2\x00\xc3\x130\x01aa\xe0\x07\x00\x00b\xe0\x08\x00\x00c\xe0\x00\x00\x01cc\x02x1\xff\x83J\xb9\xf9mX\x8a\xa6"
+	// RDB version 11 layout of Redis 7.2: aux fields, listpack hash, zset & set, quicklist (version 2) list, intset
+	RDBFile6 = "REDIS0011\xfa\x09redis-ver\x057.2.4\xfa\x0aredis-bits\xc0@\xfa\x05ctime\xc2\x00\xf1Se\xfa\x08used-mem\xc2\x00\x00\x10\x00\xfa\x08aof-base\xc0\x00\xfe\x00\xfb\x06\x01\x00\x05s_str\x05value\xfc\x008\xd3`\xba\x01\x00\x00\x00\x05s_exp\x04temp\x10\x06h_hash\x15\x15\x00\x00\x00\x04\x00\x82f1\x03\x82v1\x03\x82f2\x03\x02\x01\xff\x11\x06z_zset\x16\x16\x00\x00\x00\x04\x00\x82m1\x03\x01\x01\x82m2\x03\x832.5\x04\xff\x12\x06l_list\x01\x02\x0f\x0f\x00\x00\x00\x03\x00\x81a\x02\x81b\x02\x03\x01\xff\x0b\x05i_set\x0e\x02\x00\x00\x00\x03\x00\x00\x00\x01\x00\x02\x00\x03\x00\x14\x05p_set\x0d\x0d\x00\x00\x00\x02\x00\x81x\x02\x81y\x02\xff\xff\x122\xd3l\xbeB\xc6\x98"
+)
@@ -0,0 +1,190 @@
+package resharding
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// keyReport holds statistics on keys collected in report mode
+type keyReport struct {
+	matched        int64
+	unmatched      int64
+	matchedBytes   int64
+	unmatchedBytes int64
+	// number of keys per value type
+	types map[string]int64
+	// kept & skipped keys per database and value type
+	databases map[int]map[string]*typeStats
+}
+
+// typeStats counts keys of single value type in single database, sizes are sizes in source RDB
+type typeStats struct {
+	Kept         int64 `json:"kept"`
+	Skipped      int64 `json:"skipped"`
+	KeptBytes    int64 `json:"kept_bytes"`
+	SkippedBytes int64 `json:"skipped_bytes"`
+}
+
+func newKeyReport() *keyReport {
+	return &keyReport{types: make(map[string]int64), databases: make(map[int]map[string]*typeStats)}
+}
+
+// Account RDB entry, used as RDBFilter entryDone callback
+func (report *keyReport) add(entry RDBEntry, kept bool) {
+	types := report.databases[entry.DB]
+	if types == nil {
+		types = make(map[string]*typeStats)
+		report.databases[entry.DB] = types
+	}
+	stats := types[entry.Type]
+	if stats == nil {
+		stats = &typeStats{}
+		types[entry.Type] = stats
+	}
+
+	if kept {
+		report.matched++
+		report.matchedBytes += int64(entry.Size)
+		stats.Kept++
+		stats.KeptBytes += int64(entry.Size)
+	} else {
+		report.unmatched++
+		report.unmatchedBytes += int64(entry.Size)
+		stats.Skipped++
+		stats.SkippedBytes += int64(entry.Size)
+	}
+
+	report.types[entry.Type]++
+}
+
+// Numbers of databases in report, sorted
+func sortedDatabases(databases map[int]map[string]*typeStats) []int {
+	dbs := make([]int, 0, len(databases))
+	for db := range databases {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	return dbs
+}
+
+// Names of value types in database, sorted
+func sortedTypes(types map[string]*typeStats) []string {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Print summary of the report
+func (report *keyReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Keys matched:   %d\n", report.matched)
+	fmt.Fprintf(w, "Keys unmatched: %d\n", report.unmatched)
+	fmt.Fprintf(w, "Matched size:   %d bytes\n", report.matchedBytes)
+	fmt.Fprintf(w, "Unmatched size: %d bytes\n", report.unmatchedBytes)
+
+	types := make([]string, 0, len(report.types))
+	for name := range report.types {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+
+	fmt.Fprintln(w, "Keys by type:")
+	for _, name := range types {
+		fmt.Fprintf(w, "  %-8s %d\n", name, report.types[name])
+	}
+
+	fmt.Fprintln(w, "Keys by database and type (kept/skipped, bytes):")
+	for _, db := range sortedDatabases(report.databases) {
+		for _, name := range sortedTypes(report.databases[db]) {
+			stats := report.databases[db][name]
+			fmt.Fprintf(w, "  db%-4d %-8s %d/%d keys, %d/%d bytes\n", db, name, stats.Kept, stats.Skipped, stats.KeptBytes, stats.SkippedBytes)
+		}
+	}
+}
+
+// reportDatabase is breakdown of single database in JSON report
+type reportDatabase struct {
+	DB    int                   `json:"db"`
+	Types map[string]*typeStats `json:"types"`
+}
+
+// Write report as JSON object, databases are sorted by number
+func (report *keyReport) printJSON(w io.Writer) error {
+	output := struct {
+		Matched        int64            `json:"matched"`
+		Unmatched      int64            `json:"unmatched"`
+		MatchedBytes   int64            `json:"matched_bytes"`
+		UnmatchedBytes int64            `json:"unmatched_bytes"`
+		Databases      []reportDatabase `json:"databases"`
+	}{
+		Matched:        report.matched,
+		Unmatched:      report.unmatched,
+		MatchedBytes:   report.matchedBytes,
+		UnmatchedBytes: report.unmatchedBytes,
+		Databases:      []reportDatabase{},
+	}
+
+	for _, db := range sortedDatabases(report.databases) {
+		output.Databases = append(output.Databases, reportDatabase{DB: db, Types: report.databases[db]})
+	}
+
+	return json.NewEncoder(w).Encode(output)
+}
+
+// Connect to master, run SYNC and collect statistics on keys matching filter
+func (p *Proxy) collectReport() (*keyReport, error) {
+	conn, reader, _, err := p.requestRDB()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	report := newKeyReport()
+
+	filter := newRDBFilter(reader, ioutil.Discard, func(entry RDBEntry) bool {
+		return p.bigEnough(entry) && p.Matcher.Match(entry.Key)
+	}, 0)
+	filter.entryDone = report.add
+	filter.verify = p.VerifyRDB
+	filter.strict = p.StrictRDB
+	filter.dbFilter = p.dbSelected
+	if p.FieldPattern != nil {
+		filter.memberFilter = p.FieldPattern.MatchString
+	}
+
+	err = filter.run()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read RDB: %v", err)
+	}
+
+	return report, nil
+}
+
+// Report connects to master, runs SYNC and writes statistics on keys matching filter to w,
+// keys aren't forwarded anywhere
+func (p *Proxy) Report(w io.Writer) error {
+	report, err := p.collectReport()
+	if err != nil {
+		return err
+	}
+
+	report.print(w)
+
+	return nil
+}
+
+// ReportJSON works like Report, but writes statistics as JSON object, so that it could be
+// processed by scripts
+func (p *Proxy) ReportJSON(w io.Writer) error {
+	report, err := p.collectReport()
+	if err != nil {
+		return err
+	}
+
+	return report.printJSON(w)
+}
@@ -0,0 +1,100 @@
+package resharding
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestKeyReport(t *testing.T) {
+	report := newKeyReport()
+
+	filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(RDBFile1)), ioutil.Discard, KeyFilter(func(key string) bool { return strings.HasPrefix(key, "a_") }), 0)
+	filter.entryDone = report.add
+
+	err := filter.run()
+	if err != nil {
+		t.Fatalf("Filtering failed: %v", err)
+	}
+
+	if report.matched != 2 || report.unmatched != 3 {
+		t.Errorf("Key counts don't match: %d matched, %d unmatched", report.matched, report.unmatched)
+	}
+
+	// "\x00\x03a_1\x04lala" + "\x00\x03a_2\xc0!"
+	if report.matchedBytes != 17 {
+		t.Errorf("Matched size doesn't match: %d", report.matchedBytes)
+	}
+
+	if report.types["string"] != 5 {
+		t.Errorf("Type counts don't match: %#v", report.types)
+	}
+
+	var buf bytes.Buffer
+	report.print(&buf)
+
+	if !strings.Contains(buf.String(), "Keys matched:   2\n") {
+		t.Errorf("Summary doesn't match: %s", buf.String())
+	}
+}
+
+func TestKeyReportDatabases(t *testing.T) {
+	report := newKeyReport()
+
+	filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(RDBFile2)), ioutil.Discard, KeyFilter(func(key string) bool { return strings.HasPrefix(key, "v02") }), 0)
+	filter.entryDone = report.add
+	filter.dbFilter = func(db int) bool { return db != 7 }
+
+	err := filter.run()
+	if err != nil {
+		t.Fatalf("Filtering failed: %v", err)
+	}
+
+	var kept, skipped, size int64
+	for db, types := range report.databases {
+		for name, stats := range types {
+			if db == 7 && stats.Kept > 0 {
+				t.Errorf("Keys from filtered out database shouldn't be kept: %s %#v", name, stats)
+			}
+			kept += stats.Kept
+			skipped += stats.Skipped
+			size += stats.KeptBytes + stats.SkippedBytes
+		}
+	}
+
+	if kept != report.matched || skipped != report.unmatched || kept == 0 || skipped == 0 {
+		t.Errorf("Key counts don't match: %d/%d != %d/%d", kept, skipped, report.matched, report.unmatched)
+	}
+	if size != report.matchedBytes+report.unmatchedBytes || size == 0 {
+		t.Errorf("Sizes don't match: %d != %d + %d", size, report.matchedBytes, report.unmatchedBytes)
+	}
+}
+
+func TestKeyReportJSON(t *testing.T) {
+	report := newKeyReport()
+	report.add(RDBEntry{Key: "a_1", Type: "string", DB: 0, Size: 10}, true)
+	report.add(RDBEntry{Key: "b_1", Type: "hash", DB: 0, Size: 9}, false)
+	report.add(RDBEntry{Key: "a_2", Type: "string", DB: 2, Size: 7}, true)
+
+	var buf bytes.Buffer
+	err := report.printJSON(&buf)
+	if err != nil {
+		t.Fatalf("Unable to encode report: %v", err)
+	}
+
+	expected := `{"matched":2,"unmatched":1,"matched_bytes":17,"unmatched_bytes":9,"databases":[` +
+		`{"db":0,"types":{"hash":{"kept":0,"skipped":1,"kept_bytes":0,"skipped_bytes":9},"string":{"kept":1,"skipped":0,"kept_bytes":10,"skipped_bytes":0}}},` +
+		`{"db":2,"types":{"string":{"kept":1,"skipped":0,"kept_bytes":7,"skipped_bytes":0}}}]}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Output not equal to expected %s != %s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	report.print(&buf)
+
+	if !strings.Contains(buf.String(), "  db2    string   1/0 keys, 7/0 bytes\n") {
+		t.Errorf("Summary doesn't match: %s", buf.String())
+	}
+}
@@ -0,0 +1,263 @@
+package resharding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseFullResync extracts replication id and offset from +FULLRESYNC reply
+func parseFullResync(reply string) (replID string, offset int64, err error) {
+	fields := strings.Fields(reply)
+	if len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		return "", 0, fmt.Errorf("Malformed FULLRESYNC reply: %q", reply)
+	}
+
+	offset, err = strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("Unable to parse FULLRESYNC offset: %v", err)
+	}
+
+	return fields[1], offset, nil
+}
+
+// RESP3 types which are passed through as replies
+const resp3Types = "%~>=,#_(!|"
+
+type redisCommand struct {
+	raw      []byte
+	command  []string
+	reply    string
+	bulkSize int64
+	// EOF mark delimiting diskless RDB transfer, bulkSize is unknown in this case
+	eofMark string
+}
+
+// Length of EOF mark used by master for diskless RDB transfer
+const eofMarkLength = 40
+
+// Limit of number of multi-bulk command arguments, same as Redis default
+const maxCommandArgs = 1024 * 1024
+
+// DefaultMaxArgumentLength is default limit of single command argument or bulk value in bytes,
+// the same as Redis default of 512 MB
+const DefaultMaxArgumentLength int64 = 512 * 1024 * 1024
+
+// biggest length which fits into int on current platform
+const maxInt = int64(^uint(0) >> 1)
+
+// Check length of bulk value read from stream, CRLF is read together with value
+func checkBulkLength(size, maxArgumentLength int64) error {
+	if size > maxArgumentLength {
+		return fmt.Errorf("Argument length %d exceeds maximum of %d bytes", size, maxArgumentLength)
+	}
+	if size > maxInt-2 {
+		return fmt.Errorf("Argument length %d doesn't fit into memory on this platform", size)
+	}
+	return nil
+}
+
+// Read the rest of RESP value which starts with header, appending it to raw
+func readRedisValue(reader *bufio.Reader, header string, raw []byte, maxArgumentLength int64) ([]byte, error) {
+	if len(header) == 0 {
+		return raw, nil
+	}
+
+	switch header[0] {
+	case '$', '=', '!':
+		// bulk string, verbatim string, blob error
+		size, err := strconv.ParseInt(strings.TrimSpace(header[1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode bulk size: %v", err)
+		}
+		if size < 0 {
+			return raw, nil
+		}
+		err = checkBulkLength(size, maxArgumentLength)
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read bulk data: %v", err)
+		}
+
+		return append(raw, data...), nil
+	case '*', '%', '~', '>', '|':
+		// array, map, set, push, attribute
+		count, err := strconv.ParseInt(strings.TrimSpace(header[1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse aggregate length: %v", err)
+		}
+		if count > maxCommandArgs {
+			return nil, fmt.Errorf("Wrong aggregate length: %d", count)
+		}
+		if header[0] == '%' || header[0] == '|' {
+			count *= 2
+		}
+
+		for i := int64(0); i < count; i++ {
+			element, err := reader.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read aggregate element: %v", err)
+			}
+
+			raw, err = readRedisValue(reader, element, append(raw, []byte(element)...), maxArgumentLength)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return raw, nil
+	}
+
+	// single line value
+	return raw, nil
+}
+
+// ParseCommand reads single RESP command (or reply) from reader, returning command arguments
+// and exact bytes which were read, args are nil for replies, bulk headers & empty commands
+func ParseCommand(reader *bufio.Reader) (args []string, raw []byte, err error) {
+	command, err := readRedisCommand(reader, DefaultMaxArgumentLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return command.command, command.raw, nil
+}
+
+// Read RESP command or reply, inline commands are supported as fallback; argument or bulk value
+// longer than maxArgumentLength fails reading instead of allocating huge buffer
+func readRedisCommand(reader *bufio.Reader, maxArgumentLength int64) (*redisCommand, error) {
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read command: %v", err)
+	}
+
+	if header == "\n" || header == "\r\n" {
+		// empty command
+		return &redisCommand{raw: []byte(header)}, nil
+	}
+
+	if strings.HasPrefix(header, "+") {
+		return &redisCommand{raw: []byte(header), reply: strings.TrimSpace(header[1:])}, nil
+	}
+
+	if strings.HasPrefix(header, "-") || strings.HasPrefix(header, ":") {
+		// error & integer replies keep type prefix, so that they aren't confused with status replies
+		return &redisCommand{raw: []byte(header), reply: strings.TrimSpace(header)}, nil
+	}
+
+	if strings.HasPrefix(header, "$EOF:") {
+		// diskless RDB transfer, RDB is followed by the same mark
+		mark := strings.TrimSpace(header[5:])
+		if len(mark) != eofMarkLength {
+			return nil, fmt.Errorf("Wrong EOF mark length: %d", len(mark))
+		}
+		return &redisCommand{raw: []byte(header), eofMark: mark}, nil
+	}
+
+	if strings.HasPrefix(header, "$") {
+		bulkSize, err := strconv.ParseInt(strings.TrimSpace(header[1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode bulk size: %v", err)
+		}
+		return &redisCommand{raw: []byte(header), bulkSize: bulkSize}, nil
+	}
+
+	if strings.IndexByte(resp3Types, header[0]) != -1 {
+		// RESP3 reply, passed through with payload intact
+		raw, err := readRedisValue(reader, header, []byte(header), maxArgumentLength)
+		if err != nil {
+			return nil, err
+		}
+		return &redisCommand{raw: raw, reply: strings.TrimSpace(header)}, nil
+	}
+
+	if strings.HasPrefix(header, "*") {
+		cmdSize, err := strconv.ParseInt(strings.TrimSpace(header[1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse command length: %v", err)
+		}
+		if cmdSize == -1 || cmdSize == 0 {
+			// null array & empty command, passed through like empty line
+			return &redisCommand{raw: []byte(header)}, nil
+		}
+		if cmdSize < 0 || cmdSize > maxCommandArgs {
+			return nil, fmt.Errorf("Wrong command length: %d", cmdSize)
+		}
+
+		result := &redisCommand{raw: []byte(header), command: make([]string, cmdSize)}
+
+		for i := range result.command {
+			header, err = reader.ReadString('\n')
+			if !strings.HasPrefix(header, "$") || err != nil {
+				return nil, fmt.Errorf("Failed to read command: %v", err)
+			}
+
+			result.raw = append(result.raw, []byte(header)...)
+
+			argSize, err := strconv.ParseInt(strings.TrimSpace(header[1:]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse argument length: %v", err)
+			}
+			if argSize < 0 {
+				return nil, fmt.Errorf("Wrong argument length: %d", argSize)
+			}
+			err = checkBulkLength(argSize, maxArgumentLength)
+			if err != nil {
+				return nil, err
+			}
+
+			// argument is followed by CRLF, which is read & checked together with argument,
+			// so that malformed stream doesn't swallow next line
+			argument := make([]byte, argSize+2)
+			_, err = io.ReadFull(reader, argument)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read argument: %v", err)
+			}
+			if argument[argSize] != '\r' || argument[argSize+1] != '\n' {
+				return nil, fmt.Errorf("Argument isn't terminated with CRLF")
+			}
+
+			result.raw = append(result.raw, argument...)
+
+			result.command[i] = string(argument[:argSize])
+		}
+
+		return result, nil
+	}
+
+	// inline command, arguments are separated by whitespace
+	return &redisCommand{raw: []byte(header), command: strings.Fields(header)}, nil
+}
+
+// Line terminator of everything proxy produces itself; data read from master is passed through
+// with its own terminators (LF-only lines are tolerated on input), only synthetic commands
+// and replies are built with crlf
+const crlf = "\r\n"
+
+// Encode command as RESP multi-bulk
+func encodeRedisCommand(args ...string) []byte {
+	result := []byte(fmt.Sprintf("*%d"+crlf, len(args)))
+
+	for _, arg := range args {
+		result = append(result, []byte(fmt.Sprintf("$%d"+crlf, len(arg)))...)
+		result = append(result, []byte(arg)...)
+		result = append(result, crlf...)
+	}
+
+	return result
+}
+
+// Encode RESP error reply, line breaks in message are replaced with spaces
+// to keep reply well-formed
+func encodeRedisError(format string, args ...interface{}) []byte {
+	message := strings.NewReplacer("\r", " ", "\n", " ").Replace(fmt.Sprintf(format, args...))
+
+	return []byte("-" + message + crlf)
+}
@@ -0,0 +1,383 @@
+package resharding
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadRedisCommand(t *testing.T) {
+	tests := []struct {
+		description   string
+		input         string
+		expected      redisCommand
+		expectedError error
+	}{
+		{
+			description:   "1: Reply",
+			input:         "+PONG\r\n",
+			expected:      redisCommand{reply: "PONG"},
+			expectedError: nil,
+		},
+		{
+			description:   "2: Empty command",
+			input:         "\n",
+			expected:      redisCommand{},
+			expectedError: nil,
+		},
+		{
+			description:   "3: Simple command",
+			input:         "SYNC\r\n",
+			expected:      redisCommand{command: []string{"SYNC"}},
+			expectedError: nil,
+		},
+		{
+			description:   "3a: Inline command with arguments",
+			input:         "SET foo  bar\r\n",
+			expected:      redisCommand{command: []string{"SET", "foo", "bar"}},
+			expectedError: nil,
+		},
+		{
+			description:   "4: Bulk reply",
+			input:         "$4568\r\n",
+			expected:      redisCommand{bulkSize: 4568},
+			expectedError: nil,
+		},
+		{
+			description:   "5: Complex command",
+			input:         "*3\r\n$3\r\nSET\r\n$5\r\nmykey\r\n$7\r\nmyvalue\r\n",
+			expected:      redisCommand{command: []string{"SET", "mykey", "myvalue"}},
+			expectedError: nil,
+		},
+		{
+			description:   "6: Immediate EOF",
+			input:         "+PONG",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Failed to read command: %v", io.EOF),
+		},
+		{
+			description:   "7: EOF in length",
+			input:         "*3\r\n$3",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Failed to read command: %v", io.EOF),
+		},
+		{
+			description:   "8: EOF in data",
+			input:         "*3\r\n$3\r\nSE",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Failed to read argument: %v", io.ErrUnexpectedEOF),
+		},
+		{
+			description:   "9: Unparsable length",
+			input:         "*x\r\n",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Unable to parse command length: strconv.ParseInt: parsing \"x\": invalid syntax"),
+		},
+		{
+			description:   "10: RESP3 map",
+			input:         "%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n*2\r\n#t\r\n_\r\n",
+			expected:      redisCommand{reply: "%2"},
+			expectedError: nil,
+		},
+		{
+			description:   "11: RESP3 set",
+			input:         "~2\r\n,3.14\r\n(3492890328409238509324850943850943825024385\r\n",
+			expected:      redisCommand{reply: "~2"},
+			expectedError: nil,
+		},
+		{
+			description:   "12: RESP3 push",
+			input:         ">3\r\n$7\r\nmessage\r\n$3\r\nfoo\r\n$-1\r\n",
+			expected:      redisCommand{reply: ">3"},
+			expectedError: nil,
+		},
+		{
+			description:   "13: RESP3 verbatim string",
+			input:         "=15\r\ntxt:Some string\r\n",
+			expected:      redisCommand{reply: "=15"},
+			expectedError: nil,
+		},
+		{
+			description:   "14: RESP3 boolean",
+			input:         "#f\r\n",
+			expected:      redisCommand{reply: "#f"},
+			expectedError: nil,
+		},
+		{
+			description:   "15: RESP3 null",
+			input:         "_\r\n",
+			expected:      redisCommand{reply: "_"},
+			expectedError: nil,
+		},
+		{
+			description:   "16: RESP3 truncated map",
+			input:         "%1\r\n+first\r\n",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Failed to read aggregate element: %v", io.EOF),
+		},
+		{
+			description:   "16a: Negative command length",
+			input:         "*-2\r\n",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Wrong command length: -2"),
+		},
+		{
+			description:   "16a1: Null array",
+			input:         "*-1\r\n",
+			expected:      redisCommand{},
+			expectedError: nil,
+		},
+		{
+			description:   "16a2: Empty array",
+			input:         "*0\r\n",
+			expected:      redisCommand{},
+			expectedError: nil,
+		},
+		{
+			description:   "16b: Negative argument length",
+			input:         "*1\r\n$-5\r\n",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Wrong argument length: -5"),
+		},
+		{
+			description:   "16c: Argument without CRLF",
+			input:         "*2\r\n$3\r\nGETxx\r\n$1\r\na\r\n",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Argument isn't terminated with CRLF"),
+		},
+		{
+			description:   "16d: Error reply",
+			input:         "-ERR wrong number of arguments\r\n",
+			expected:      redisCommand{reply: "-ERR wrong number of arguments"},
+			expectedError: nil,
+		},
+		{
+			description:   "16e: Integer reply",
+			input:         ":1\r\n",
+			expected:      redisCommand{reply: ":1"},
+			expectedError: nil,
+		},
+		{
+			description:   "17: Diskless RDB header",
+			input:         "$EOF:0123456789abcdef0123456789abcdef01234567\r\n",
+			expected:      redisCommand{eofMark: "0123456789abcdef0123456789abcdef01234567"},
+			expectedError: nil,
+		},
+		{
+			description:   "18: Short EOF mark",
+			input:         "$EOF:0123\r\n",
+			expected:      redisCommand{},
+			expectedError: fmt.Errorf("Wrong EOF mark length: 4"),
+		},
+	}
+
+	for _, test := range tests {
+		test.expected.raw = []byte(test.input)
+
+		command, err := readRedisCommand(bufio.NewReader(bytes.NewBufferString(test.input)), DefaultMaxArgumentLength)
+		if err != nil {
+			if test.expectedError == nil || test.expectedError.Error() != err.Error() {
+				t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+			}
+		} else if test.expectedError != nil {
+			t.Errorf("Should have failed with %v (test %s)", test.expectedError, test.description)
+		} else if !reflect.DeepEqual(*command, test.expected) {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", *command, test.expected, test.description)
+		}
+	}
+}
+
+func TestReadRedisCommandMaxArgumentLength(t *testing.T) {
+	tests := []struct {
+		description   string
+		input         string
+		expectedError error
+	}{
+		{"1: Argument within limit", "*1\r\n$4\r\nPING\r\n", nil},
+		{"2: Argument over limit", "*2\r\n$3\r\nGET\r\n$5\r\nmykey\r\n", fmt.Errorf("Argument length 5 exceeds maximum of 4 bytes")},
+		{"3: Huge argument", "*1\r\n$9223372036854775807\r\n", fmt.Errorf("Argument length 9223372036854775807 exceeds maximum of 4 bytes")},
+		{"4: RESP3 bulk over limit", "%1\r\n$5\r\nfield\r\n:1\r\n", fmt.Errorf("Argument length 5 exceeds maximum of 4 bytes")},
+	}
+
+	for _, test := range tests {
+		_, err := readRedisCommand(bufio.NewReader(bytes.NewBufferString(test.input)), 4)
+		if fmt.Sprint(err) != fmt.Sprint(test.expectedError) {
+			t.Errorf("Unexpected error %v != %v (test %s)", err, test.expectedError, test.description)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		description string
+		input       []string
+		expected    [][]string
+	}{
+		{
+			description: "1: Multi-bulk commands",
+			input:       []string{"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", "*3\r\n$3\r\nSET\r\n$0\r\n\r\n$4\r\na\r\nb\r\n"},
+			expected:    [][]string{{"GET", "key"}, {"SET", "", "a\r\nb"}},
+		},
+		{
+			description: "2: Bulk header & replies",
+			input:       []string{"+FULLRESYNC abc 1\r\n", "\n", "$10\r\n", "-ERR\r\n", ":5\r\n"},
+			expected:    [][]string{nil, nil, nil, nil, nil},
+		},
+		{
+			description: "3: Inline commands",
+			input:       []string{"PING\r\n", "REPLCONF ACK 100\n"},
+			expected:    [][]string{{"PING"}, {"REPLCONF", "ACK", "100"}},
+		},
+		{
+			description: "4: Mixed LF & CRLF framing",
+			input:       []string{"*1\n$4\r\nPING\r\n", "+OK\n", "-ERR x\n", ":1\n", "\r\n", "$10\n", "PING\n"},
+			expected:    [][]string{{"PING"}, nil, nil, nil, nil, nil, {"PING"}},
+		},
+	}
+
+	for _, test := range tests {
+		reader := bufio.NewReader(bytes.NewBufferString(strings.Join(test.input, "")))
+
+		for i := range test.input {
+			args, raw, err := ParseCommand(reader)
+			if err != nil {
+				t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+				break
+			}
+			if !reflect.DeepEqual(args, test.expected[i]) {
+				t.Errorf("Arguments not equal to expected %#v != %#v (test %s)", args, test.expected[i], test.description)
+			}
+			if string(raw) != test.input[i] {
+				t.Errorf("Raw bytes don't round-trip %#v != %#v (test %s)", string(raw), test.input[i], test.description)
+			}
+		}
+
+		_, _, err := ParseCommand(reader)
+		if err == nil {
+			t.Errorf("Input should be fully consumed (test %s)", test.description)
+		}
+	}
+}
+
+// Read commands until error, returning parsed commands & final error
+func readAllCommands(reader *bufio.Reader) ([]redisCommand, string) {
+	var result []redisCommand
+
+	for {
+		command, err := readRedisCommand(reader, DefaultMaxArgumentLength)
+		if err != nil {
+			return result, err.Error()
+		}
+		result = append(result, *command)
+	}
+}
+
+// Parse input at once and byte by byte (as if every byte arrived in separate TCP segment),
+// results should be identical
+func checkPartialReads(t *testing.T, input []byte) {
+	whole, wholeErr := readAllCommands(bufio.NewReader(bytes.NewReader(input)))
+	partial, partialErr := readAllCommands(bufio.NewReaderSize(iotest.OneByteReader(bytes.NewReader(input)), 16))
+
+	if !reflect.DeepEqual(whole, partial) || wholeErr != partialErr {
+		t.Errorf("Byte by byte parsing differs for %#v: %#v (%s) != %#v (%s)", string(input), partial, partialErr, whole, wholeErr)
+	}
+}
+
+func TestReadRedisCommandPartialReads(t *testing.T) {
+	inputs := []string{
+		"*3\r\n$3\r\nSET\r\n$10\r\nmykey12345\r\n$7\r\nmyvalue\r\n*1\r\n$4\r\nPING\r\n",
+		"+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 2443808505\r\n\n\n$4568\r\n",
+		"%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n*2\r\n#t\r\n_\r\nPING\r\n",
+		"*2\r\n$3\r\nGET\r\n$1",
+	}
+
+	for _, input := range inputs {
+		checkPartialReads(t, []byte(input))
+	}
+}
+
+func FuzzReadRedisCommand(f *testing.F) {
+	f.Add([]byte("*3\r\n$3\r\nSET\r\n$5\r\nmykey\r\n$7\r\nmyvalue\r\n"))
+	f.Add([]byte("+PONG\r\n\r\nPING\r\n"))
+	f.Add([]byte(">3\r\n$7\r\nmessage\r\n$3\r\nfoo\r\n$-1\r\n"))
+	f.Add([]byte("$EOF:0123456789abcdef0123456789abcdef01234567\r\n"))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		checkPartialReads(t, input)
+	})
+}
+
+func TestParseFullResync(t *testing.T) {
+	tests := []struct {
+		description string
+		reply       string
+		replID      string
+		offset      int64
+		shouldFail  bool
+	}{
+		{
+			description: "1: Valid reply",
+			reply:       "FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 2",
+			replID:      "8de1787ba490483314a4d30f1c628bc5025eb761",
+			offset:      2,
+		},
+		{
+			description: "2: Missing offset",
+			reply:       "FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761",
+			shouldFail:  true,
+		},
+		{
+			description: "3: Wrong offset",
+			reply:       "FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 x",
+			shouldFail:  true,
+		},
+		{
+			description: "4: Not FULLRESYNC",
+			reply:       "CONTINUE",
+			shouldFail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		replID, offset, err := parseFullResync(test.reply)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("Should have failed (test %s)", test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+		} else if replID != test.replID || offset != test.offset {
+			t.Errorf("Output not equal to expected %s %d != %s %d (test %s)", replID, offset, test.replID, test.offset, test.description)
+		}
+	}
+}
+
+func TestEncodeRedisCommand(t *testing.T) {
+	encoded := string(encodeRedisCommand("AUTH", "user", "pass word"))
+	if encoded != "*3\r\n$4\r\nAUTH\r\n$4\r\nuser\r\n$9\r\npass word\r\n" {
+		t.Errorf("Encoded command doesn't match: %#v", encoded)
+	}
+
+	command, err := readRedisCommand(bufio.NewReader(bytes.NewBufferString(encoded)), DefaultMaxArgumentLength)
+	if err != nil {
+		t.Fatalf("Unable to parse encoded command: %v", err)
+	}
+	if !reflect.DeepEqual(command.command, []string{"AUTH", "user", "pass word"}) {
+		t.Errorf("Parsed command doesn't match: %#v", command.command)
+	}
+}
+
+func TestEncodeRedisError(t *testing.T) {
+	encoded := string(encodeRedisError("ERR unknown command '%s'", "FOO\r\nBAR"))
+	if encoded != "-ERR unknown command 'FOO  BAR'\r\n" {
+		t.Errorf("Encoded error doesn't match: %#v", encoded)
+	}
+}
@@ -0,0 +1,141 @@
+package resharding
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+// restoreWriter converts kept RDB entries to RESTORE commands, so that filtered keys
+// could be loaded into any Redis over command protocol (e.g. with redis-cli --pipe)
+type restoreWriter struct {
+	w *bufio.Writer
+	// REPLACE is added to RESTORE, so that existing keys are overwritten
+	replace bool
+	// databases are renumbered with SELECT according to dbMap
+	dbMap map[int]int
+	// database selected by last SELECT, -1 if none sent yet
+	db int
+	// current time, keys which have expired by then are not restored
+	now func() time.Time
+
+	restored int64
+	expired  int64
+}
+
+func newRestoreWriter(w io.Writer, replace bool, dbMap map[int]int) *restoreWriter {
+	return &restoreWriter{
+		w:       bufio.NewWriterSize(w, bufSize),
+		replace: replace,
+		dbMap:   dbMap,
+		db:      -1,
+		now:     time.Now,
+	}
+}
+
+// Encode RESTORE command for key with TTL in milliseconds (0 for no expiry) and value in DUMP format;
+// with replace REPLACE option is added, so that existing key is overwritten instead of failing the command
+func restoreCommand(key string, ttl int64, payload []byte, replace bool) []byte {
+	args := []string{"RESTORE", key, strconv.FormatInt(ttl, 10), string(payload)}
+	if replace {
+		args = append(args, "REPLACE")
+	}
+
+	return encodeRedisCommand(args...)
+}
+
+// Build DUMP payload: serialized value (starting with type byte), RDB version as 2 bytes
+// and CRC64 of everything before, both little endian
+func dumpPayload(value []byte, rdbVersion int) []byte {
+	payload := make([]byte, len(value), len(value)+10)
+	copy(payload, value)
+
+	payload = append(payload, byte(rdbVersion), byte(rdbVersion>>8))
+
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, CRC64Update(0, payload))
+
+	return append(payload, checksum...)
+}
+
+// Write RESTORE command for kept entry, preceded by SELECT if entry is in another database;
+// expiry is absolute time in milliseconds, RESTORE gets TTL in milliseconds relative to now
+func (restore *restoreWriter) write(entry RDBEntry, value []byte, rdbVersion int) error {
+	ttl := int64(0)
+	if entry.Expiry > 0 {
+		ttl = entry.Expiry - restore.now().UnixNano()/int64(time.Millisecond)
+		if ttl <= 0 {
+			// RESTORE treats 0 as no expiry, expired key shouldn't be restored at all
+			restore.expired++
+			return nil
+		}
+	}
+
+	db := entry.DB
+	if target, ok := restore.dbMap[db]; ok {
+		db = target
+	}
+	if db != restore.db {
+		_, err := restore.w.Write(encodeRedisCommand("SELECT", strconv.Itoa(db)))
+		if err != nil {
+			return err
+		}
+		restore.db = db
+	}
+
+	_, err := restore.w.Write(restoreCommand(entry.Key, ttl, dumpPayload(value, rdbVersion), restore.replace))
+	if err != nil {
+		return err
+	}
+	restore.restored++
+
+	return nil
+}
+
+// RestoreCommands connects to master, runs SYNC and writes RESTORE command for every key kept
+// by filter to w, so that it could be piped into any Redis (redis-cli --pipe) instead of loading RDB;
+// SELECT is written whenever database changes. With ReplaceExisting RESTORE overwrites existing keys.
+//
+// Oversized entries are skipped even if StreamOversized is set, as whole value is needed for RESTORE.
+func (p *Proxy) RestoreCommands(w io.Writer) error {
+	conn, reader, _, err := p.requestRDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	restore := newRestoreWriter(w, p.ReplaceExisting, p.DBMap)
+
+	filter := newRDBFilter(reader, ioutil.Discard, p.countingEntryMatches, 0)
+	filter.keptEntry = func(entry RDBEntry, value []byte) error {
+		return restore.write(entry, value, filter.rdbVersion)
+	}
+	filter.verify = p.VerifyRDB
+	filter.strict = p.StrictRDB
+	filter.dbFilter = p.dbSelected
+	filter.maxValueSize = p.MaxValueSize
+	if p.FieldPattern != nil {
+		filter.memberFilter = p.FieldPattern.MatchString
+	}
+	if p.Rewriter != nil {
+		filter.rename = p.Rewriter.Rewrite
+	}
+
+	err = filter.run()
+	if err != nil {
+		return fmt.Errorf("Unable to convert RDB: %v", err)
+	}
+
+	err = restore.w.Flush()
+	if err != nil {
+		return fmt.Errorf("Failed to write RESTORE commands: %v", err)
+	}
+
+	logInfo("RDB converted to %d RESTORE commands, %d expired keys skipped", restore.restored, restore.expired)
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package resharding
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreCommand(t *testing.T) {
+	tests := []struct {
+		description string
+		ttl         int64
+		replace     bool
+		expected    string
+	}{
+		{"1: No expiry", 0, false, "*4\r\n$7\r\nRESTORE\r\n$3\r\na_1\r\n$1\r\n0\r\n$4\r\ndump\r\n"},
+		{"2: TTL", 1500, false, "*4\r\n$7\r\nRESTORE\r\n$3\r\na_1\r\n$4\r\n1500\r\n$4\r\ndump\r\n"},
+		{"3: REPLACE", 0, true, "*5\r\n$7\r\nRESTORE\r\n$3\r\na_1\r\n$1\r\n0\r\n$4\r\ndump\r\n$7\r\nREPLACE\r\n"},
+	}
+
+	for _, test := range tests {
+		command := string(restoreCommand("a_1", test.ttl, []byte("dump"), test.replace))
+		if command != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", command, test.expected, test.description)
+		}
+	}
+}
+
+func TestDumpPayload(t *testing.T) {
+	// DUMP of integer 10 by Redis with RDB version 9, from Redis documentation
+	expected := "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+
+	payload := string(dumpPayload([]byte("\x00\xc0\n"), 9))
+	if payload != expected {
+		t.Errorf("Output not equal to expected %#v != %#v", payload, expected)
+	}
+}
+
+func TestRestoreWriter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	tests := []struct {
+		description string
+		replace     bool
+		dbMap       map[int]int
+		expected    string
+	}{
+		{
+			description: "1: Plain RESTORE",
+			expected: string(encodeRedisCommand("SELECT", "0")) +
+				string(encodeRedisCommand("RESTORE", "a_1", "0", "\x00\x04lala\x09\x00"+crcOf("\x00\x04lala\x09\x00"))) +
+				string(encodeRedisCommand("RESTORE", "a_2", "1500", "\x00\x01x\x09\x00"+crcOf("\x00\x01x\x09\x00"))) +
+				string(encodeRedisCommand("SELECT", "3")) +
+				string(encodeRedisCommand("RESTORE", "b_1", "0", "\x00\x01y\x09\x00"+crcOf("\x00\x01y\x09\x00"))),
+		},
+		{
+			description: "2: REPLACE, databases mapped",
+			replace:     true,
+			dbMap:       map[int]int{3: 1},
+			expected: string(encodeRedisCommand("SELECT", "0")) +
+				string(encodeRedisCommand("RESTORE", "a_1", "0", "\x00\x04lala\x09\x00"+crcOf("\x00\x04lala\x09\x00"), "REPLACE")) +
+				string(encodeRedisCommand("RESTORE", "a_2", "1500", "\x00\x01x\x09\x00"+crcOf("\x00\x01x\x09\x00"), "REPLACE")) +
+				string(encodeRedisCommand("SELECT", "1")) +
+				string(encodeRedisCommand("RESTORE", "b_1", "0", "\x00\x01y\x09\x00"+crcOf("\x00\x01y\x09\x00"), "REPLACE")),
+		},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+
+		restore := newRestoreWriter(&output, test.replace, test.dbMap)
+		restore.now = func() time.Time { return now }
+
+		restore.write(RDBEntry{Key: "a_1"}, []byte("\x00\x04lala"), 9)
+		restore.write(RDBEntry{Key: "a_2", Expiry: nowMs + 1500}, []byte("\x00\x01x"), 9)
+		// already expired
+		restore.write(RDBEntry{Key: "a_3", Expiry: nowMs - 1}, []byte("\x00\x01z"), 9)
+		restore.write(RDBEntry{Key: "b_1", DB: 3}, []byte("\x00\x01y"), 9)
+		restore.w.Flush()
+
+		if output.String() != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", output.String(), test.expected, test.description)
+		}
+		if restore.restored != 3 || restore.expired != 1 {
+			t.Errorf("Counts don't match: %d restored, %d expired (test %s)", restore.restored, restore.expired, test.description)
+		}
+	}
+}
+
+// Little endian CRC64 of data, as in DUMP payload
+func crcOf(data string) string {
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, CRC64Update(0, []byte(data)))
+	return string(checksum)
+}
+
+func TestFilterRDBKeptEntry(t *testing.T) {
+	type kept struct {
+		key   string
+		value string
+	}
+	var entries []kept
+
+	filter := newRDBFilter(bufio.NewReader(bytes.NewBufferString(RDBFile1)), ioutil.Discard, KeyFilter(func(key string) bool { return strings.HasPrefix(key, "a_") }), 0)
+	filter.rename = func(key string) string { return "new:" + key }
+	filter.keptEntry = func(entry RDBEntry, value []byte) error {
+		entries = append(entries, kept{entry.Key, string(value)})
+		return nil
+	}
+
+	err := filter.run()
+	if err != nil {
+		t.Fatalf("Filtering failed: %v", err)
+	}
+
+	expected := []kept{{"new:a_1", "\x00\x04lala"}, {"new:a_2", "\x00\xc0!"}}
+	if len(entries) != len(expected) || entries[0] != expected[0] || entries[1] != expected[1] {
+		t.Errorf("Output not equal to expected %#v != %#v", entries, expected)
+	}
+}
@@ -0,0 +1,111 @@
+package resharding
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KeyRewriter renames keys with regular expression replacement
+type KeyRewriter struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// ParseRewrite parses rewrite rule in form /old/new/, any character could be used as delimiter
+func ParseRewrite(spec string) (*KeyRewriter, error) {
+	if len(spec) < 3 {
+		return nil, fmt.Errorf("Rewrite rule %q should be in form /old/new/", spec)
+	}
+
+	delimiter := spec[:1]
+	parts := strings.Split(spec[1:], delimiter)
+	if len(parts) != 3 || parts[2] != "" {
+		return nil, fmt.Errorf("Rewrite rule %q should be in form /old/new/", spec)
+	}
+
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Wrong format of regular expression %q: %v", parts[0], err)
+	}
+
+	return &KeyRewriter{re: re, replacement: parts[1]}, nil
+}
+
+// Rewrite returns new name of the key
+func (rewriter *KeyRewriter) Rewrite(key string) string {
+	return rewriter.re.ReplaceAllString(key, rewriter.replacement)
+}
+
+// Rewrite all keys of the command (positions from command table), re-encoding it if any key has changed
+func (rewriter *KeyRewriter) RewriteCommand(command *redisCommand) {
+	var args []string
+
+	for _, i := range commandKeyPositions(command) {
+		key := rewriter.Rewrite(command.command[i])
+		if key == command.command[i] {
+			continue
+		}
+
+		if args == nil {
+			args = make([]string, len(command.command))
+			copy(args, command.command)
+		}
+		args[i] = key
+	}
+
+	if args == nil {
+		return
+	}
+
+	command.command = args
+	command.raw = encodeRedisCommand(args...)
+}
+
+// ParseDBMap parses database mapping in form 3:0,4:1 (source:target), which
+// could be merged into existing mapping; source database could be mapped only once
+func ParseDBMap(spec string, dbmap map[int]int) (map[int]int, error) {
+	if dbmap == nil {
+		dbmap = make(map[int]int)
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		pair := strings.Split(strings.TrimSpace(part), ":")
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("Database mapping %q should be in form source:target", part)
+		}
+
+		source, err := strconv.Atoi(pair[0])
+		if err != nil || source < 0 {
+			return nil, fmt.Errorf("Wrong source database %q", pair[0])
+		}
+		target, err := strconv.Atoi(pair[1])
+		if err != nil || target < 0 {
+			return nil, fmt.Errorf("Wrong target database %q", pair[1])
+		}
+
+		if _, ok := dbmap[source]; ok {
+			return nil, fmt.Errorf("Database %d is mapped more than once", source)
+		}
+		dbmap[source] = target
+	}
+
+	return dbmap, nil
+}
+
+// Renumber database of SELECT command according to database mapping
+func remapSelect(command *redisCommand, dbmap map[int]int) {
+	db, ok := selectedDB(command)
+	if !ok {
+		return
+	}
+
+	target, ok := dbmap[db]
+	if !ok {
+		return
+	}
+
+	command.command = []string{command.command[0], strconv.Itoa(target)}
+	command.raw = encodeRedisCommand(command.command...)
+}
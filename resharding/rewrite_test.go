@@ -0,0 +1,199 @@
+package resharding
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRewrite(t *testing.T) {
+	tests := []struct {
+		description string
+		spec        string
+		key         string
+		expected    string
+		shouldFail  bool
+	}{
+		{
+			description: "1: Strip prefix",
+			spec:        "/^shard[0-9]+://",
+			key:         "shard12:user:1",
+			expected:    "user:1",
+		},
+		{
+			description: "2: Replacement with groups, custom delimiter",
+			spec:        "|^(user):([0-9]+)$|${1}s:$2|",
+			key:         "user:15",
+			expected:    "users:15",
+		},
+		{
+			description: "3: Not matching",
+			spec:        "/^shard[0-9]+://",
+			key:         "user:1",
+			expected:    "user:1",
+		},
+		{
+			description: "4: Missing trailing delimiter",
+			spec:        "/old/new",
+			shouldFail:  true,
+		},
+		{
+			description: "5: Wrong regexp",
+			spec:        "/(/new/",
+			shouldFail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		rewriter, err := ParseRewrite(test.spec)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("Should have failed (test %s)", test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+		} else if rewriter.Rewrite(test.key) != test.expected {
+			t.Errorf("Output not equal to expected %q != %q (test %s)", rewriter.Rewrite(test.key), test.expected, test.description)
+		}
+	}
+}
+
+func TestRewriteCommand(t *testing.T) {
+	rewriter, _ := ParseRewrite("/^shard1://")
+
+	command := &redisCommand{raw: encodeRedisCommand("SET", "shard1:a", "1"), command: []string{"SET", "shard1:a", "1"}}
+	rewriter.RewriteCommand(command)
+
+	if !reflect.DeepEqual(command.command, []string{"SET", "a", "1"}) {
+		t.Errorf("Command not rewritten: %#v", command.command)
+	}
+	if string(command.raw) != "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n" {
+		t.Errorf("Raw command not re-encoded: %#v", string(command.raw))
+	}
+
+	raw := []byte("*2\r\n$3\r\nGET\r\n$1\r\nb\r\n")
+	command = &redisCommand{raw: raw, command: []string{"GET", "b"}}
+	rewriter.RewriteCommand(command)
+
+	if string(command.raw) != string(raw) {
+		t.Errorf("Command should be passed through unchanged: %#v", string(command.raw))
+	}
+
+	rewriter, _ = ParseRewrite("/^/new:/")
+
+	tests := []struct {
+		description string
+		command     []string
+		expected    []string
+	}{
+		{"1: BITOP", []string{"BITOP", "AND", "dest", "src1", "src2"}, []string{"BITOP", "AND", "new:dest", "new:src1", "new:src2"}},
+		{"2: MSET", []string{"MSET", "a", "1", "b", "2"}, []string{"MSET", "new:a", "1", "new:b", "2"}},
+		{"3: DEL", []string{"DEL", "a", "b", "c"}, []string{"DEL", "new:a", "new:b", "new:c"}},
+		{"4: XGROUP", []string{"XGROUP", "CREATE", "mystream", "g", "$"}, []string{"XGROUP", "CREATE", "new:mystream", "g", "$"}},
+		{"5: RENAME", []string{"RENAME", "a", "b"}, []string{"RENAME", "new:a", "new:b"}},
+		{"6: ZUNIONSTORE", []string{"ZUNIONSTORE", "dest", "2", "a", "b", "WEIGHTS", "1", "2"}, []string{"ZUNIONSTORE", "new:dest", "2", "new:a", "new:b", "WEIGHTS", "1", "2"}},
+		{"7: ZINTERSTORE", []string{"ZINTERSTORE", "dest", "1", "a", "AGGREGATE", "MAX"}, []string{"ZINTERSTORE", "new:dest", "1", "new:a", "AGGREGATE", "MAX"}},
+		{"8: ZDIFFSTORE", []string{"ZDIFFSTORE", "dest", "2", "a", "b"}, []string{"ZDIFFSTORE", "new:dest", "2", "new:a", "new:b"}},
+		{"9: ZRANGESTORE", []string{"ZRANGESTORE", "dest", "src", "0", "-1"}, []string{"ZRANGESTORE", "new:dest", "new:src", "0", "-1"}},
+		{"10: COPY", []string{"COPY", "src", "dest", "REPLACE"}, []string{"COPY", "new:src", "new:dest", "REPLACE"}},
+		{"11: PFMERGE", []string{"PFMERGE", "dest", "a", "b"}, []string{"PFMERGE", "new:dest", "new:a", "new:b"}},
+		{"12: GEOSEARCHSTORE", []string{"GEOSEARCHSTORE", "dest", "src", "FROMMEMBER", "m", "BYRADIUS", "1", "km"}, []string{"GEOSEARCHSTORE", "new:dest", "new:src", "FROMMEMBER", "m", "BYRADIUS", "1", "km"}},
+		{"13: SORT STORE", []string{"SORT", "src", "BY", "nosort", "store", "dest"}, []string{"SORT", "new:src", "BY", "nosort", "store", "new:dest"}},
+		{"14: SORT", []string{"SORT", "src", "LIMIT", "0", "10"}, []string{"SORT", "new:src", "LIMIT", "0", "10"}},
+		{"15: Unparsable numkeys", []string{"ZUNIONSTORE", "dest", "x", "a"}, []string{"ZUNIONSTORE", "new:dest", "x", "a"}},
+	}
+
+	for _, test := range tests {
+		command = &redisCommand{raw: encodeRedisCommand(test.command...), command: test.command}
+		rewriter.RewriteCommand(command)
+
+		if !reflect.DeepEqual(command.command, test.expected) {
+			t.Errorf("Command not equal to expected %#v != %#v (test %s)", command.command, test.expected, test.description)
+		}
+		if string(command.raw) != string(encodeRedisCommand(test.expected...)) {
+			t.Errorf("Raw command doesn't match command %#v (test %s)", string(command.raw), test.description)
+		}
+	}
+
+	rewriter, _ = ParseRewrite("/^GET/SET/")
+
+	raw = []byte("*3\r\n$8\r\nREPLCONF\r\n$6\r\nGETACK\r\n$1\r\n*\r\n")
+	command = &redisCommand{raw: raw, command: []string{"REPLCONF", "GETACK", "*"}}
+	rewriter.RewriteCommand(command)
+
+	if string(command.raw) != string(raw) {
+		t.Errorf("Command without keys should be passed through unchanged: %#v", string(command.raw))
+	}
+}
+
+func TestParseDBMap(t *testing.T) {
+	tests := []struct {
+		description string
+		specs       []string
+		expected    map[int]int
+		shouldFail  bool
+	}{
+		{"1: Single mapping", []string{"3:0"}, map[int]int{3: 0}, false},
+		{"2: Comma-separated", []string{"3:0, 4:1"}, map[int]int{3: 0, 4: 1}, false},
+		{"3: Repeated", []string{"3:0", "4:0"}, map[int]int{3: 0, 4: 0}, false},
+		{"4: Missing target", []string{"3"}, nil, true},
+		{"5: Negative database", []string{"3:-1"}, nil, true},
+		{"6: Not a number", []string{"a:1"}, nil, true},
+		{"7: Mapped twice", []string{"3:0", "3:1"}, nil, true},
+	}
+
+	for _, test := range tests {
+		var (
+			dbmap map[int]int
+			err   error
+		)
+		for _, spec := range test.specs {
+			dbmap, err = ParseDBMap(spec, dbmap)
+			if err != nil {
+				break
+			}
+		}
+
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("Should have failed (test %s)", test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+		} else if !reflect.DeepEqual(dbmap, test.expected) {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", dbmap, test.expected, test.description)
+		}
+	}
+}
+
+func TestRemapSelect(t *testing.T) {
+	dbmap := map[int]int{3: 0, 12: 1}
+
+	tests := []struct {
+		description string
+		command     []string
+		expected    []string
+	}{
+		{"1: Mapped database", []string{"SELECT", "3"}, []string{"SELECT", "0"}},
+		{"2: Mapped database, lower case", []string{"select", "12"}, []string{"select", "1"}},
+		{"3: Unmapped database", []string{"SELECT", "4"}, []string{"SELECT", "4"}},
+		{"4: Not SELECT", []string{"SET", "3", "1"}, []string{"SET", "3", "1"}},
+	}
+
+	for _, test := range tests {
+		command := &redisCommand{raw: encodeRedisCommand(test.command...), command: test.command}
+		remapSelect(command, dbmap)
+
+		if !reflect.DeepEqual(command.command, test.expected) {
+			t.Errorf("Command not equal to expected %#v != %#v (test %s)", command.command, test.expected, test.description)
+		}
+		if string(command.raw) != string(encodeRedisCommand(test.expected...)) {
+			t.Errorf("Raw command doesn't match command %#v (test %s)", string(command.raw), test.description)
+		}
+	}
+}
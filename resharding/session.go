@@ -0,0 +1,203 @@
+package resharding
+
+import (
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// ParseCIDRs parses comma-separated list of networks in CIDR notation, plain IP address
+// is treated as network of single address
+func ParseCIDRs(spec string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("Wrong IP address %q", part)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong network %q: %v", part, err)
+		}
+		nets = append(nets, network)
+	}
+
+	return nets, nil
+}
+
+// Check whether slave connection comes from one of AllowedNets, connections
+// over Unix socket are always allowed
+func (p *Proxy) sourceAllowed(addr net.Addr) bool {
+	if p.AllowedNets == nil {
+		return true
+	}
+
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UnixAddr:
+		return true
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+
+	for _, network := range p.AllowedNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Register slave connection, should be called before starting slaveReader,
+// returns false if maximum number of slaves has been reached
+func (p *Proxy) sessionStarted(conn net.Conn) bool {
+	p.sessionsLock.Lock()
+	defer p.sessionsLock.Unlock()
+
+	if p.MaxSlaves > 0 && len(p.sessions) >= p.MaxSlaves {
+		return false
+	}
+
+	p.sessions[conn] = struct{}{}
+	p.sessionsWg.Add(1)
+	return true
+}
+
+// Unregister slave connection when slaveReader is done
+func (p *Proxy) sessionFinished(conn net.Conn) {
+	p.sessionsLock.Lock()
+	defer p.sessionsLock.Unlock()
+
+	delete(p.sessions, conn)
+	p.sessionsWg.Done()
+}
+
+// Recover from panic in goroutine of slave session (e.g. caused by malformed stream), so that it
+// doesn't take down the whole process: panic is logged and session is torn down like on failed write
+// to slave, listener & other sessions keep running
+func recoverSession(output *slaveOutput) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	metricSessionPanics.Inc()
+	logError("Panic in session of slave %s, closing connection: %v\n%s", output.slave, r, debug.Stack())
+
+	output.cancel()
+	output.sink.Close()
+}
+
+// Interrupt reads on all slave connections, so that slaveReader stops after current command,
+// wait for them up to timeout and close remaining connections forcibly
+func (p *Proxy) shutdownSessions(timeout time.Duration) {
+	p.sessionsLock.Lock()
+	logInfo("Shutting down %d slave connection(s)", len(p.sessions))
+	for conn := range p.sessions {
+		conn.SetReadDeadline(time.Now())
+	}
+	p.sessionsLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.sessionsWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	p.sessionsLock.Lock()
+	defer p.sessionsLock.Unlock()
+
+	logWarn("Shutdown timeout expired, closing %d slave connection(s)", len(p.sessions))
+	for conn := range p.sessions {
+		conn.Close()
+	}
+}
+
+// idleTimeoutConn resets read deadline before each read, so that connection
+// fails if nothing is received within timeout
+//
+// Timeout is paused while slave is receiving RDB, as slave doesn't send anything
+// until RDB is loaded
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+	paused  bool
+}
+
+func (conn *idleTimeoutConn) Read(b []byte) (int, error) {
+	if !conn.paused {
+		err := conn.Conn.SetReadDeadline(time.Now().Add(conn.timeout))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return conn.Conn.Read(b)
+}
+
+// Pause timeout, should be called from the goroutine reading from connection
+func (conn *idleTimeoutConn) pause() {
+	conn.paused = true
+	conn.Conn.SetReadDeadline(time.Time{})
+}
+
+// Resume timeout, should be called from the goroutine reading from connection
+func (conn *idleTimeoutConn) resume() {
+	conn.paused = false
+}
+
+// deadlineConn sets read & write deadline before each operation, so that stalled
+// connection fails instead of blocking forever
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (conn *deadlineConn) Read(b []byte) (int, error) {
+	if conn.readTimeout > 0 {
+		err := conn.Conn.SetReadDeadline(time.Now().Add(conn.readTimeout))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return conn.Conn.Read(b)
+}
+
+func (conn *deadlineConn) Write(b []byte) (int, error) {
+	if conn.writeTimeout > 0 {
+		err := conn.Conn.SetWriteDeadline(time.Now().Add(conn.writeTimeout))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return conn.Conn.Write(b)
+}
@@ -0,0 +1,319 @@
+package resharding
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShutdownSessions(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	p.sessionStarted(server)
+
+	go func() {
+		defer p.sessionFinished(server)
+		buf := make([]byte, 1)
+		server.Read(buf)
+	}()
+
+	start := time.Now()
+	p.shutdownSessions(time.Second)
+
+	if time.Since(start) >= time.Second {
+		t.Errorf("Shutdown should have finished before timeout")
+	}
+
+	if len(p.sessions) != 0 {
+		t.Errorf("All sessions should have been finished, %d left", len(p.sessions))
+	}
+}
+
+func TestMaxSlaves(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+	p.MaxSlaves = 1
+
+	first, _ := net.Pipe()
+	second, _ := net.Pipe()
+
+	if !p.sessionStarted(first) {
+		t.Fatalf("First slave should have been accepted")
+	}
+
+	if p.sessionStarted(second) {
+		t.Errorf("Second slave should have been rejected")
+	}
+
+	p.sessionFinished(first)
+
+	if !p.sessionStarted(second) {
+		t.Errorf("Second slave should have been accepted after first one finished")
+	}
+
+	p.sessionFinished(second)
+}
+
+func TestIdleTimeoutConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Unable to accept: %v", err)
+	}
+	defer server.Close()
+
+	conn := &idleTimeoutConn{Conn: server, timeout: 50 * time.Millisecond}
+	buf := make([]byte, 1)
+
+	client.Write([]byte("a"))
+	_, err = conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read should have succeeded: %v", err)
+	}
+
+	conn.pause()
+	time.AfterFunc(100*time.Millisecond, func() { client.Write([]byte("b")) })
+
+	_, err = conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read shouldn't time out while paused: %v", err)
+	}
+
+	conn.resume()
+
+	_, err = conn.Read(buf)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Read should have timed out: %v", err)
+	}
+}
+
+func TestDeadlineConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Unable to accept: %v", err)
+	}
+	defer server.Close()
+
+	conn := &deadlineConn{Conn: client, readTimeout: 50 * time.Millisecond, writeTimeout: 50 * time.Millisecond}
+	buf := make([]byte, 1)
+
+	_, err = conn.Write([]byte("a"))
+	if err != nil {
+		t.Fatalf("Write should have succeeded: %v", err)
+	}
+
+	server.Write([]byte("b"))
+	_, err = conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read should have succeeded: %v", err)
+	}
+
+	start := time.Now()
+	_, err = conn.Read(buf)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Read should have timed out: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Read should have timed out within timeout")
+	}
+}
+
+func TestSourceAllowed(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+
+	if !p.sourceAllowed(&net.TCPAddr{IP: net.ParseIP("8.8.8.8"), Port: 1234}) {
+		t.Errorf("All addresses should be allowed by default")
+	}
+
+	var err error
+	p.AllowedNets, err = ParseCIDRs("10.0.0.0/8, 192.168.1.15,fd00::/8")
+	if err != nil {
+		t.Fatalf("Unable to parse networks: %v", err)
+	}
+
+	tests := []struct {
+		description string
+		addr        net.Addr
+		expected    bool
+	}{
+		{"1: Inside network", &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234}, true},
+		{"2: Outside network", &net.TCPAddr{IP: net.ParseIP("11.1.2.3"), Port: 1234}, false},
+		{"3: Single address", &net.TCPAddr{IP: net.ParseIP("192.168.1.15"), Port: 1234}, true},
+		{"4: Next to single address", &net.TCPAddr{IP: net.ParseIP("192.168.1.16"), Port: 1234}, false},
+		{"5: IPv6 network", &net.TCPAddr{IP: net.ParseIP("fd12::1"), Port: 1234}, true},
+		{"6: IPv4-mapped IPv6 address", &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 1234}, true},
+		{"7: Unix socket", &net.UnixAddr{Name: "/var/run/proxy.sock", Net: "unix"}, true},
+	}
+
+	for _, test := range tests {
+		if allowed := p.sourceAllowed(test.addr); allowed != test.expected {
+			t.Errorf("Decision doesn't match: %v != %v (test %s)", allowed, test.expected, test.description)
+		}
+	}
+
+	for _, spec := range []string{"10.0.0.0/33", "host.local", ""} {
+		if _, err := ParseCIDRs(spec); err == nil {
+			t.Errorf("Parsing %q should have failed", spec)
+		}
+	}
+}
+
+func TestServeRejectsSource(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+
+	p := NewProxy("tcp", "127.0.0.1:1")
+	p.AllowedNets, _ = ParseCIDRs("10.0.0.0/8")
+
+	done := make(chan struct{})
+	go func() {
+		p.Serve(ln)
+		close(done)
+	}()
+	defer func() {
+		p.Close()
+		<-done
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(make([]byte, 1))
+	if n != 0 || err == nil {
+		t.Errorf("Connection should have been closed by proxy: %d %v", n, err)
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Errorf("Connection wasn't closed by proxy")
+	}
+}
+
+// panicMatcher simulates bug triggered by unexpected data
+type panicMatcher struct{}
+
+func (panicMatcher) Match(key string) bool {
+	if key == "boom" {
+		panic("unexpected key")
+	}
+	return true
+}
+
+func TestSessionPanic(t *testing.T) {
+	master, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer master.Close()
+
+	// first master connection gets command before replication has even started, the second one
+	// starts replication properly
+	replies := []string{
+		string(encodeRedisCommand("SET", "boom", "1")),
+		"+CONTINUE\r\n" + string(encodeRedisCommand("SET", "a", "1")),
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		for _, reply := range replies {
+			conn, err := master.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			readRedisCommand(bufio.NewReader(conn), DefaultMaxArgumentLength)
+			io.WriteString(conn, reply)
+		}
+
+		// master connections are kept open until the end of the test
+		<-finished
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+
+	p := NewProxy("tcp", master.Addr().String())
+	p.Matcher = panicMatcher{}
+	p.MasterRetryMax = 0
+
+	done := make(chan struct{})
+	go func() {
+		p.Serve(ln)
+		close(done)
+	}()
+	defer func() {
+		p.Close()
+		<-done
+	}()
+
+	panics := metricSessionPanics.Value()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write(encodeRedisCommand("SYNC"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := ioutil.ReadAll(conn)
+	if len(data) != 0 || err != nil {
+		t.Errorf("Connection should have been closed by proxy: %q %v", data, err)
+	}
+
+	if metricSessionPanics.Value() != panics+1 {
+		t.Errorf("Panic should have been counted")
+	}
+
+	// proxy is still serving other slaves
+	conn, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write(encodeRedisCommand("PSYNC", "8de1787ba490483314a4d30f1c628bc5025eb761", "1"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	expected := "+CONTINUE\r\n" + string(encodeRedisCommand("SET", "a", "1"))
+	received := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, received)
+	if err != nil || string(received) != expected {
+		t.Errorf("Output not equal to expected %#v != %#v (%v)", expected, string(received), err)
+	}
+}
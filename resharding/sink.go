@@ -0,0 +1,270 @@
+package resharding
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// Sink receives filtered replication stream (RDB and commands) instead of slave
+//
+// Data is written in segments: every forwarded command and whole RDB is finished with Flush.
+type Sink interface {
+	io.Writer
+	// Flush delivers segment written so far
+	Flush() error
+	// Close flushes pending data and releases sink
+	Close() error
+}
+
+// connSink is default sink writing to slave connection
+type connSink struct {
+	*bufio.Writer
+	conn net.Conn
+}
+
+func newConnSink(conn net.Conn, size int) Sink {
+	return &connSink{
+		Writer: bufio.NewWriterSize(conn, size),
+		conn:   conn,
+	}
+}
+
+func (sink *connSink) Close() error {
+	return sink.conn.Close()
+}
+
+// teeSink copies everything written to sink into file, so that stream received by slave
+// could be inspected or replayed later
+//
+// Failure to write file is logged once and file is abandoned, replication to sink goes on.
+type teeSink struct {
+	Sink
+	file   *bufio.Writer
+	path   string
+	failed bool
+}
+
+func (sink *teeSink) Write(data []byte) (int, error) {
+	n, err := sink.Sink.Write(data)
+	if n > 0 && !sink.failed {
+		_, teeErr := sink.file.Write(data[:n])
+		sink.teeFailed(teeErr)
+	}
+	return n, err
+}
+
+func (sink *teeSink) Flush() error {
+	err := sink.Sink.Flush()
+	if !sink.failed {
+		sink.teeFailed(sink.file.Flush())
+	}
+	return err
+}
+
+func (sink *teeSink) teeFailed(err error) {
+	if err != nil {
+		logError("Failed to write tee file %s, stream is not copied anymore: %v", sink.path, err)
+		sink.failed = true
+	}
+}
+
+// Wrap sink with teeSink if TeeFile is set, returned function flushes & closes
+// file and should be called once nothing is written to sink anymore
+//
+// Tee file holds stream of single slave: it is truncated when slave session starts,
+// sessions started while file is in use by other slave aren't copied.
+func (p *Proxy) teeSink(sink Sink) (Sink, func()) {
+	if p.TeeFile == "" {
+		return sink, func() {}
+	}
+
+	p.teeLock.Lock()
+	defer p.teeLock.Unlock()
+
+	if p.teeBusy {
+		logWarn("Tee file %s is in use by another slave, stream of this slave is not copied", p.TeeFile)
+		return sink, func() {}
+	}
+
+	file, err := os.Create(p.TeeFile)
+	if err != nil {
+		logError("Unable to create tee file: %v", err)
+		return sink, func() {}
+	}
+	p.teeBusy = true
+
+	tee := &teeSink{Sink: sink, file: bufio.NewWriterSize(file, p.BufferSize), path: p.TeeFile}
+
+	return tee, func() {
+		if !tee.failed {
+			tee.teeFailed(tee.file.Flush())
+		}
+		file.Close()
+
+		p.teeLock.Lock()
+		p.teeBusy = false
+		p.teeLock.Unlock()
+	}
+}
+
+// httpSink POSTs every segment of replication stream to URL
+//
+// Segment is streamed as request body with chunked transfer encoding, so that RDB
+// is never buffered as a whole.
+type httpSink struct {
+	url    string
+	client *http.Client
+
+	lock   sync.Mutex
+	body   *io.PipeWriter
+	result chan error
+}
+
+// NewHTTPSink creates sink which POSTs every segment of replication stream to url
+func NewHTTPSink(url string) Sink {
+	return &httpSink{url: url, client: http.DefaultClient}
+}
+
+func (sink *httpSink) Write(data []byte) (int, error) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if sink.body == nil {
+		reader, writer := io.Pipe()
+		sink.body = writer
+		sink.result = make(chan error, 1)
+
+		go sink.post(reader, sink.result)
+	}
+
+	return sink.body.Write(data)
+}
+
+// Run single POST request, body is fed by Write until Flush
+func (sink *httpSink) post(body *io.PipeReader, result chan<- error) {
+	response, err := sink.client.Post(sink.url, "application/octet-stream", body)
+	if err == nil {
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+
+		if response.StatusCode/100 != 2 {
+			err = fmt.Errorf("Sink responded with %s", response.Status)
+		}
+	} else {
+		err = fmt.Errorf("Failed to POST to sink: %v", err)
+	}
+
+	// unblock Write if request has failed before reading whole body
+	if err != nil {
+		body.CloseWithError(err)
+	} else {
+		body.Close()
+	}
+	result <- err
+}
+
+func (sink *httpSink) Flush() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if sink.body == nil {
+		return nil
+	}
+
+	sink.body.Close()
+	err := <-sink.result
+	sink.body = nil
+
+	return err
+}
+
+func (sink *httpSink) Close() error {
+	return sink.Flush()
+}
+
+// ParseSink creates sink from URL, only http(s) is supported now
+func ParseSink(spec string) (Sink, error) {
+	parsed, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed.Scheme {
+	case "http", "https":
+		return NewHTTPSink(spec), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported sink %q, expected http:// or https:// URL", spec)
+}
+
+// ReplicateToSink requests replication from master and sends filtered stream to sink,
+// sink works as slave which never sends anything back
+//
+// Returns once either master connection or sink fails.
+func (p *Proxy) ReplicateToSink(sink Sink) error {
+	if p.sizeThresholds() {
+		return ErrSizeThresholds
+	}
+
+	slavechannel := make(chan []byte, p.SlaveQueueSize)
+	slaveQueues.add(slavechannel)
+	defer slaveQueues.remove(slavechannel)
+
+	masterchannel := make(chan []byte, p.MasterQueueSize)
+	masterQueues.add(masterchannel)
+	defer masterQueues.remove(masterchannel)
+
+	request := &syncRequest{}
+	offset := &replicationOffset{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sink, releaseTee := p.teeSink(sink)
+	output := newSlaveOutput(sink, p.RateLimit, cancel)
+	output.slave = "sink"
+
+	go slaveWriter(ctx, output, slavechannel)
+	go func() {
+		<-output.done
+		releaseTee()
+	}()
+
+	if p.NoRDB {
+		request.set(p.partialSyncRequest(offset))
+	} else {
+		logInfo("Starting SYNC")
+		request.set(encodeRedisCommand("SYNC"))
+	}
+	masterchannel <- request.get()
+
+	var masterErr error
+	finished := make(chan struct{})
+	go func() {
+		masterErr = p.masterConnection(ctx, sink, output, slavechannel, masterchannel, request, offset)
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		// data queued before master connection failed is still delivered
+		close(slavechannel)
+		<-output.done
+		if masterErr == ErrFullResync {
+			return masterErr
+		}
+		return fmt.Errorf("Master connection is closed")
+	case <-output.done:
+		// failed write has cancelled ctx, master connection is closed
+		<-finished
+		return fmt.Errorf("Failed to write data to sink")
+	}
+}
@@ -0,0 +1,207 @@
+package resharding
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSink(t *testing.T) {
+	var bodies []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL)
+
+	sink.Write([]byte("REDIS"))
+	sink.Write([]byte("0006\xff"))
+	err := sink.Flush()
+	if err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	sink.Write(encodeRedisCommand("SET", "a", "1"))
+	err = sink.Close()
+	if err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	expected := []string{"REDIS0006\xff", "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"}
+	if len(bodies) != len(expected) || bodies[0] != expected[0] || bodies[1] != expected[1] {
+		t.Errorf("Output not equal to expected %#v != %#v", expected, bodies)
+	}
+}
+
+func TestHTTPSinkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no space left", http.StatusInsufficientStorage)
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL)
+
+	sink.Write([]byte("PING"))
+	err := sink.Flush()
+	if err == nil || err.Error() != "Sink responded with 507 Insufficient Storage" {
+		t.Errorf("Flush should have failed with sink status: %v", err)
+	}
+}
+
+func TestParseSink(t *testing.T) {
+	tests := []struct {
+		description string
+		spec        string
+		valid       bool
+	}{
+		{"1: HTTP", "http://localhost:8080/replication", true},
+		{"2: HTTPS", "https://importer/", true},
+		{"3: Kafka", "kafka://broker:9092/topic", false},
+		{"4: No scheme", "importer:8080", false},
+	}
+
+	for _, test := range tests {
+		_, err := ParseSink(test.spec)
+		if (err == nil) != test.valid {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", test.valid, err == nil, test.description)
+		}
+	}
+}
+
+// bufferSink collects everything written to it
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (sink *bufferSink) Flush() error {
+	return nil
+}
+
+func (sink *bufferSink) Close() error {
+	return nil
+}
+
+func TestTeeSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tee")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := NewProxy("tcp", "localhost:6379")
+	p.TeeFile = filepath.Join(dir, "stream.bin")
+
+	slave := &bufferSink{}
+	sink, release := p.teeSink(slave)
+
+	sink.Write([]byte("REDIS0006\xff"))
+	sink.Flush()
+	sink.Write(encodeRedisCommand("SET", "a", "1"))
+
+	// tee file is in use, second slave isn't copied
+	other := &bufferSink{}
+	otherSink, otherRelease := p.teeSink(other)
+	if otherSink != Sink(other) {
+		t.Errorf("Second slave sink is wrapped while tee file is in use")
+	}
+	otherRelease()
+
+	release()
+
+	expected := "REDIS0006\xff*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	if slave.String() != expected {
+		t.Errorf("Output not equal to expected %#v != %#v", expected, slave.String())
+	}
+
+	tee, err := ioutil.ReadFile(p.TeeFile)
+	if err != nil {
+		t.Fatalf("Unable to read tee file: %v", err)
+	}
+	if string(tee) != expected {
+		t.Errorf("Tee file not equal to expected %#v != %#v", expected, string(tee))
+	}
+
+	// tee file is truncated for the next slave
+	sink, release = p.teeSink(&bufferSink{})
+	sink.Write([]byte("+OK\r\n"))
+	release()
+
+	tee, err = ioutil.ReadFile(p.TeeFile)
+	if err != nil {
+		t.Fatalf("Unable to read tee file: %v", err)
+	}
+	if string(tee) != "+OK\r\n" {
+		t.Errorf("Tee file not equal to expected %#v != %#v", "+OK\r\n", string(tee))
+	}
+}
+
+func TestReplicateToSinkNoRDB(t *testing.T) {
+	tests := []struct {
+		description string
+		stream      string
+		expected    string
+		err         error
+	}{
+		{"1: Partial resync", "+CONTINUE\r\n" + string(encodeRedisCommand("SET", "a_1", "1")) + string(encodeRedisCommand("SET", "b_1", "1")),
+			"+CONTINUE\r\n" + string(encodeRedisCommand("SET", "a_1", "1")), nil},
+		{"2: Full resync", "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n$10\r\nREDIS0006\xff", "", ErrFullResync},
+	}
+
+	for _, test := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Unable to listen: %v", err)
+		}
+
+		requests := make(chan string, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			command, err := readRedisCommand(bufio.NewReader(conn), DefaultMaxArgumentLength)
+			if err != nil {
+				requests <- err.Error()
+				return
+			}
+			requests <- strings.Join(command.command, " ")
+			io.WriteString(conn, test.stream)
+		}()
+
+		p := NewProxy("tcp", ln.Addr().String())
+		p.MasterRetryMax = 0
+		p.Matcher = PrefixMatcher{"a_"}
+		p.NoRDB = true
+		p.ReplID = "8de1787ba490483314a4d30f1c628bc5025eb761"
+		p.ReplOffset = 1001
+
+		sink := &bufferSink{}
+		err = p.ReplicateToSink(sink)
+		ln.Close()
+
+		if test.err != nil && err != test.err || test.err == nil && err == nil {
+			t.Errorf("Error doesn't match: %v (test %s)", err, test.description)
+		}
+
+		if request := <-requests; request != "PSYNC 8de1787ba490483314a4d30f1c628bc5025eb761 1001" {
+			t.Errorf("Request doesn't match: %q (test %s)", request, test.description)
+		}
+
+		if sink.String() != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", test.expected, sink.String(), test.description)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package resharding
+
+import (
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const clusterSlots = 16384
+
+// HashFunc hashes key (already reduced to its hash tag) for slot computation
+type HashFunc func(key []byte) uint32
+
+// SlotHashes are hash functions available for slot computation by name, crc16 is the one
+// used by Redis Cluster
+var SlotHashes = map[string]HashFunc{
+	"crc16": func(key []byte) uint32 { return uint32(CRC16(key)) },
+	"crc32": crc32.ChecksumIEEE,
+	"fnv1a": func(key []byte) uint32 {
+		h := fnv.New32a()
+		h.Write(key)
+		return h.Sum32()
+	},
+}
+
+// SlotHashNames lists names of SlotHashes, sorted
+func SlotHashNames() []string {
+	names := make([]string, 0, len(SlotHashes))
+	for name := range SlotHashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Part of the key used for hashing: contents of the first non-empty {...} hash tag, or whole key
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+
+	return key
+}
+
+// KeySlot calculates slot for the key with hash function modulo number of slots, honoring hash tags
+func KeySlot(key string, hash HashFunc, slots int) int {
+	return int(hash([]byte(hashTag(key))) % uint32(slots))
+}
+
+// KeyHashSlot calculates Redis Cluster hash slot for the key, honoring hash tags
+func KeyHashSlot(key string) int {
+	return int(CRC16([]byte(hashTag(key)))) % clusterSlots
+}
+
+// ParseSlotRanges parses list of slot ranges like 0-5460,10000,10001-10100
+func ParseSlotRanges(spec string) ([]IntRange, error) {
+	return ParseRanges(spec, "slot", clusterSlots-1)
+}
+
+// ParseShard parses shard specification like 2/4 (shard 2 of 4, numbered from 0),
+// returns shard number and total number of shards
+func ParseShard(spec string) (int, int, error) {
+	parts := strings.Split(strings.TrimSpace(spec), "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Shard %q should be in format N/total", spec)
+	}
+
+	shard, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Wrong shard number %q: %v", parts[0], err)
+	}
+	total, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Wrong number of shards %q: %v", parts[1], err)
+	}
+
+	if total < 1 {
+		return 0, 0, fmt.Errorf("Number of shards should be positive: %d", total)
+	}
+	if shard < 0 || shard >= total {
+		return 0, 0, fmt.Errorf("Shard number %d out of bounds 0-%d", shard, total-1)
+	}
+
+	return shard, total, nil
+}
@@ -0,0 +1,144 @@
+package resharding
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"somekey", 11058},
+		{"{user1000}.following", KeyHashSlot("user1000")},
+		{"{user1000}.followers", KeyHashSlot("user1000")},
+		{"foo{}{bar}", KeyHashSlot("foo{}{bar}")},
+		{"foo{{bar}}zap", KeyHashSlot("{bar")},
+		{"foo{bar}{zap}", KeyHashSlot("bar")},
+	}
+
+	for _, test := range tests {
+		slot := KeyHashSlot(test.key)
+		if slot != test.slot {
+			t.Errorf("Slot for key %q doesn't match: %d != %d", test.key, slot, test.slot)
+		}
+	}
+
+	if KeyHashSlot("{user1000}.following") != KeyHashSlot("{user1000}.followers") {
+		t.Errorf("Keys with the same hash tag should map to the same slot")
+	}
+}
+
+func TestParseSlotRanges(t *testing.T) {
+	tests := []struct {
+		description string
+		spec        string
+		expected    []IntRange
+		shouldFail  bool
+	}{
+		{
+			description: "1: Single range",
+			spec:        "0-5460",
+			expected:    []IntRange{{0, 5460}},
+		},
+		{
+			description: "2: Several ranges & single slot",
+			spec:        "0-100, 200,16000-16383",
+			expected:    []IntRange{{0, 100}, {200, 200}, {16000, 16383}},
+		},
+		{
+			description: "3: Out of bounds",
+			spec:        "0-16384",
+			shouldFail:  true,
+		},
+		{
+			description: "4: Reversed range",
+			spec:        "100-0",
+			shouldFail:  true,
+		},
+		{
+			description: "5: Garbage",
+			spec:        "a-b",
+			shouldFail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		ranges, err := ParseSlotRanges(test.spec)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("Should have failed (test %s)", test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+		} else if !reflect.DeepEqual(ranges, test.expected) {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", ranges, test.expected, test.description)
+		}
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		description string
+		key         string
+		hash        string
+		slots       int
+		expected    int
+	}{
+		{"1: CRC16, cluster slots", "foo", "crc16", 16384, 12182},
+		{"2: CRC16, hash tag", "{user1000}.following", "crc16", 16384, KeyHashSlot("user1000")},
+		{"3: CRC16, shards", "foo", "crc16", 4, 12182 % 4},
+		{"4: CRC32", "foo", "crc32", 10, 2356372769 % 10},
+		{"5: FNV-1a", "foo", "fnv1a", 10, 2851307223 % 10},
+		{"6: FNV-1a, hash tag", "{foo}bar", "fnv1a", 10, 2851307223 % 10},
+		{"7: Single slot", "bar", "crc32", 1, 0},
+	}
+
+	for _, test := range tests {
+		slot := KeySlot(test.key, SlotHashes[test.hash], test.slots)
+		if slot != test.expected {
+			t.Errorf("Output not equal to expected %#v != %#v (test %s)", slot, test.expected, test.description)
+		}
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	tests := []struct {
+		description string
+		spec        string
+		shard       int
+		total       int
+		shouldFail  bool
+	}{
+		{description: "1: First shard", spec: "0/4", shard: 0, total: 4},
+		{description: "2: Last shard", spec: "3/4", shard: 3, total: 4},
+		{description: "3: Single shard", spec: " 0/1 ", shard: 0, total: 1},
+		{description: "4: Out of bounds", spec: "4/4", shouldFail: true},
+		{description: "5: Negative", spec: "-1/4", shouldFail: true},
+		{description: "6: Zero total", spec: "0/0", shouldFail: true},
+		{description: "7: No total", spec: "2", shouldFail: true},
+		{description: "8: Garbage", spec: "a/b", shouldFail: true},
+	}
+
+	for _, test := range tests {
+		shard, total, err := ParseShard(test.spec)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("Should have failed (test %s)", test.description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v (test %s)", err, test.description)
+		} else if shard != test.shard || total != test.total {
+			t.Errorf("Output not equal to expected %d/%d != %d/%d (test %s)", shard, total, test.shard, test.total, test.description)
+		}
+	}
+}
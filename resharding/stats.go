@@ -0,0 +1,40 @@
+package resharding
+
+import (
+	"fmt"
+	"time"
+)
+
+// One-line summary of replication state, built from metrics & health
+func (p *Proxy) statsLine() string {
+	health := p.Health()
+
+	master := "no slaves"
+	if health.Slaves > 0 {
+		if health.MasterConnected {
+			master = "master connected"
+		} else {
+			master = fmt.Sprintf("master down (%d of %d connections up)", health.Masters, health.Slaves)
+		}
+	}
+
+	return fmt.Sprintf("Stats: %d commands forwarded, %d filtered, offset %d (master %d), %d slave(s), %s",
+		metricForwardedCommands.Value(), metricFilteredCommands.Value(),
+		metricForwardedOffset.Value(), metricMasterOffset.Value(), health.Slaves, master)
+}
+
+// LogStats logs summary of replication state every interval until proxy is closed,
+// it gives heartbeat of long runs without scraping metrics
+func (p *Proxy) LogStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			logInfo("%s", p.statsLine())
+		case <-p.closed:
+			return
+		}
+	}
+}
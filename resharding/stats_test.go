@@ -0,0 +1,46 @@
+package resharding
+
+import (
+	"net"
+	"testing"
+)
+
+func TestStatsLine(t *testing.T) {
+	p := NewProxy("tcp", "localhost:6379")
+
+	forwarded, filtered := metricForwardedCommands.Value(), metricFilteredCommands.Value()
+	forwardedOffset, masterOffset := metricForwardedOffset.Value(), metricMasterOffset.Value()
+	defer func() {
+		metricForwardedCommands.Set(forwarded)
+		metricFilteredCommands.Set(filtered)
+		metricForwardedOffset.Set(forwardedOffset)
+		metricMasterOffset.Set(masterOffset)
+	}()
+
+	metricForwardedCommands.Set(10)
+	metricFilteredCommands.Set(5)
+	metricForwardedOffset.Set(1200)
+	metricMasterOffset.Set(1500)
+
+	expected := "Stats: 10 commands forwarded, 5 filtered, offset 1200 (master 1500), 0 slave(s), no slaves"
+	if line := p.statsLine(); line != expected {
+		t.Errorf("Output not equal to expected %#v != %#v (test 1: No slaves)", line, expected)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	p.sessionStarted(server)
+	defer p.sessionFinished(server)
+
+	expected = "Stats: 10 commands forwarded, 5 filtered, offset 1200 (master 1500), 1 slave(s), master down (0 of 1 connections up)"
+	if line := p.statsLine(); line != expected {
+		t.Errorf("Output not equal to expected %#v != %#v (test 2: Master down)", line, expected)
+	}
+
+	p.masters = 1
+
+	expected = "Stats: 10 commands forwarded, 5 filtered, offset 1200 (master 1500), 1 slave(s), master connected"
+	if line := p.statsLine(); line != expected {
+		t.Errorf("Output not equal to expected %#v != %#v (test 3: Master connected)", line, expected)
+	}
+}
@@ -0,0 +1,37 @@
+package resharding
+
+import (
+	"testing"
+)
+
+func TestProxyTLSConfig(t *testing.T) {
+	_, err := ProxyTLSConfig("cert.pem", "")
+	if err == nil {
+		t.Errorf("Should have failed without key")
+	}
+
+	_, err = ProxyTLSConfig("/nonexistent/cert.pem", "/nonexistent/key.pem")
+	if err == nil {
+		t.Errorf("Should have failed with missing files")
+	}
+}
+
+func TestMasterTLSConfig(t *testing.T) {
+	config, err := MasterTLSConfig("redis.example.com", "", "", "", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.ServerName != "redis.example.com" || !config.InsecureSkipVerify || config.RootCAs != nil {
+		t.Errorf("TLS config doesn't match: %#v", config)
+	}
+
+	_, err = MasterTLSConfig("redis.example.com", "/nonexistent/ca.pem", "", "", false)
+	if err == nil {
+		t.Errorf("Should have failed with missing CA bundle")
+	}
+
+	_, err = MasterTLSConfig("redis.example.com", "", "cert.pem", "", false)
+	if err == nil {
+		t.Errorf("Should have failed without client key")
+	}
+}
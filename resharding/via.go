@@ -0,0 +1,251 @@
+package resharding
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ParseConnectVia parses URL of proxy master connections are dialed through:
+// socks5://[user:password@]host:port or http://[user:password@]host:port (HTTP CONNECT)
+func ParseConnectVia(spec string) (*url.URL, error) {
+	via, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch via.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy %q, expected socks5:// or http:// URL", spec)
+	}
+
+	if via.Port() == "" {
+		return nil, fmt.Errorf("Proxy port is missing in %q", spec)
+	}
+
+	return via, nil
+}
+
+// bufferedConn returns data read ahead by handshake reader before reading from connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+// Dial master address, directly or through ConnectVia proxy; host name of master is resolved by proxy
+func (p *Proxy) dialMasterConn(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: p.MasterConnectTimeout}
+
+	if p.ConnectVia == nil {
+		return dialer.DialContext(ctx, p.MasterNetwork, addr)
+	}
+
+	if p.MasterNetwork != "tcp" {
+		return nil, fmt.Errorf("Master at %s %s can't be reached through proxy", p.MasterNetwork, addr)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", p.ConnectVia.Host)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to proxy %s: %v", p.ConnectVia.Host, err)
+	}
+
+	// handshake with proxy is a part of connecting
+	deadline, ok := ctx.Deadline()
+	if p.MasterConnectTimeout > 0 && (!ok || time.Now().Add(p.MasterConnectTimeout).Before(deadline)) {
+		deadline, ok = time.Now().Add(p.MasterConnectTimeout), true
+	}
+	if ok {
+		conn.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	if p.ConnectVia.Scheme == "http" {
+		err = httpConnect(conn, reader, p.ConnectVia.User, addr)
+	} else {
+		err = socks5Connect(conn, reader, p.ConnectVia.User, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Unable to connect to %s through proxy %s: %v", addr, p.ConnectVia.Host, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// Request tunnel to addr with HTTP CONNECT
+func httpConnect(conn net.Conn, reader *bufio.Reader, user *url.Userinfo, addr string) error {
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+
+	_, err := io.WriteString(conn, request+"\r\n")
+	if err != nil {
+		return err
+	}
+
+	response, err := http.ReadResponse(reader, &http.Request{Method: "CONNECT"})
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Proxy replied %s", response.Status)
+	}
+
+	return nil
+}
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socks5Version      = 5
+	socks5NoAuth       = 0
+	socks5PasswordAuth = 2
+	socks5CmdConnect   = 1
+	socks5AddrIPv4     = 1
+	socks5AddrDomain   = 3
+	socks5AddrIPv6     = 4
+)
+
+// Reply codes of SOCKS5 proxy
+var socks5Errors = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// Request tunnel to addr with SOCKS5 CONNECT, authenticating with user name & password if given
+func socks5Connect(conn net.Conn, reader *bufio.Reader, user *url.Userinfo, addr string) error {
+	host, portSpec, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portSpec)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("Wrong port %q", portSpec)
+	}
+
+	method := byte(socks5NoAuth)
+	if user != nil {
+		method = socks5PasswordAuth
+	}
+
+	_, err = conn.Write([]byte{socks5Version, 1, method})
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(reader, reply)
+	if err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("Unexpected SOCKS version %d", reply[0])
+	}
+	if reply[1] != method {
+		return fmt.Errorf("SOCKS proxy doesn't accept authentication method %d", method)
+	}
+
+	if method == socks5PasswordAuth {
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return fmt.Errorf("SOCKS user name or password is too long")
+		}
+
+		auth := []byte{1, byte(len(user.Username()))}
+		auth = append(auth, user.Username()...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		_, err = conn.Write(auth)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.ReadFull(reader, reply)
+		if err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("SOCKS authentication failed")
+		}
+	}
+
+	request := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		request = append(append(request, socks5AddrIPv4), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, socks5AddrIPv6), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("Host name %q is too long", host)
+		}
+		request = append(append(request, socks5AddrDomain, byte(len(host))), host...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+
+	_, err = conn.Write(request)
+	if err != nil {
+		return err
+	}
+
+	// version, reply code, reserved, address type
+	header := make([]byte, 4)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if int(header[1]) < len(socks5Errors) {
+			return fmt.Errorf("SOCKS proxy replied: %s", socks5Errors[header[1]])
+		}
+		return fmt.Errorf("SOCKS proxy replied with code %d", header[1])
+	}
+
+	// bound address & port are skipped
+	var skip int
+	switch header[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		skip = int(length)
+	default:
+		return fmt.Errorf("Unexpected SOCKS address type %d", header[3])
+	}
+
+	_, err = io.ReadFull(reader, make([]byte, skip+2))
+	return err
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build metadata, could be set at build time:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD)"
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// Version line printed by -version and logged at startup
+func versionString() string {
+	return fmt.Sprintf("redis-resharding-proxy %s (commit %s, %s)", version, commit, runtime.Version())
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	version, commit = "1.2.0", "abc1234"
+	defer func() { version, commit = "dev", "unknown" }()
+
+	expected := "redis-resharding-proxy 1.2.0 (commit abc1234, " + runtime.Version() + ")"
+	if versionString() != expected {
+		t.Errorf("Version doesn't match: %q != %q", versionString(), expected)
+	}
+}